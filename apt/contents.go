@@ -0,0 +1,126 @@
+package apt
+
+// This file implements a parser for Contents indices.
+//
+// The specification is:
+// https://wiki.debian.org/DebianRepository/Format#A.22Contents.22_indices
+
+import (
+	"bufio"
+	"io"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// ContentsEntry is an entry of Contents indices.
+type ContentsEntry struct {
+	// Path is the path of a file without the leading slash,
+	// such as "usr/bin/curl".
+	Path string
+
+	// Packages is a list of qualified package names shipping the file,
+	// such as "web/curl" or "universe/net/curl".
+	Packages []string
+}
+
+// PackageNames returns the package names in Packages without
+// areas and sections.
+func (e *ContentsEntry) PackageNames() []string {
+	names := make([]string, len(e.Packages))
+	for i, q := range e.Packages {
+		names[i] = q[strings.LastIndexByte(q, '/')+1:]
+	}
+	return names
+}
+
+// ContentsParser reads entries from Contents indices one by one.
+//
+// Headers of old Contents indices are ignored if any.
+type ContentsParser struct {
+	s       *bufio.Scanner
+	maxSize int
+	lineno  int
+}
+
+// NewContentsParser creates a parser from a io.Reader.
+//
+// Lines longer than DefaultMaxLineSize are rejected.
+func NewContentsParser(r io.Reader) *ContentsParser {
+	s := bufio.NewScanner(r)
+	s.Buffer(make([]byte, initialBufferSize), DefaultMaxLineSize+1)
+	return &ContentsParser{
+		s:       s,
+		maxSize: DefaultMaxLineSize,
+	}
+}
+
+func isContentsHeader(l string) bool {
+	t := strings.Fields(l)
+	return len(t) == 2 && t[0] == "FILE" && t[1] == "LOCATION"
+}
+
+// skipHeader skips the header of old Contents indices.
+// The header is a free text ending with "FILE LOCATION" line.
+func (p *ContentsParser) skipHeader(first string) error {
+	for p.s.Scan() {
+		p.lineno++
+		if isContentsHeader(p.s.Text()) {
+			return nil
+		}
+	}
+	if err := p.scanErr(); err != nil {
+		return err
+	}
+	return errors.New("no end of header: " + first)
+}
+
+func (p *ContentsParser) scanErr() error {
+	err := p.s.Err()
+	if err == bufio.ErrTooLong {
+		return errors.Wrapf(ErrLineTooLong, "line %d exceeds %d bytes", p.lineno+1, p.maxSize)
+	}
+	return err
+}
+
+// Read reads an entry.
+//
+// It returns io.EOF if no more entry can be read.
+func (p *ContentsParser) Read() (*ContentsEntry, error) {
+	for p.s.Scan() {
+		p.lineno++
+		l := strings.TrimRight(p.s.Text(), " \t")
+		if len(l) == 0 {
+			continue
+		}
+
+		if p.lineno == 1 && strings.HasPrefix(l, "This file maps each file") {
+			if err := p.skipHeader(l); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if isContentsHeader(l) {
+			continue
+		}
+
+		// file names may contain spaces, but locations do not.
+		i := strings.LastIndexAny(l, " \t")
+		if i < 0 {
+			return nil, errors.Errorf("invalid line %d: %s", p.lineno, l)
+		}
+		fpath := strings.TrimRight(l[:i], " \t")
+		if len(fpath) == 0 {
+			return nil, errors.Errorf("invalid line %d: %s", p.lineno, l)
+		}
+		return &ContentsEntry{
+			Path:     strings.TrimPrefix(fpath, "/"),
+			Packages: strings.Split(l[i+1:], ","),
+		}, nil
+	}
+
+	if err := p.scanErr(); err != nil {
+		return nil, err
+	}
+	return nil, io.EOF
+}
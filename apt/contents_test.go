@@ -0,0 +1,68 @@
+package apt
+
+import (
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestContentsParser(t *testing.T) {
+	t.Parallel()
+
+	const modern = `usr/bin/curl                                            web/curl
+usr/share/doc/a file with spaces/README                 universe/doc/foo,doc/bar
+etc/sudoers	admin/sudo,admin/sudo-ldap
+`
+	p := NewContentsParser(strings.NewReader(modern))
+	e, err := p.Read()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.Path != "usr/bin/curl" || !reflect.DeepEqual(e.Packages, []string{"web/curl"}) {
+		t.Error(`unexpected entry`, e)
+	}
+	e, err = p.Read()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.Path != "usr/share/doc/a file with spaces/README" {
+		t.Error(`unexpected path`, e.Path)
+	}
+	if !reflect.DeepEqual(e.PackageNames(), []string{"foo", "bar"}) {
+		t.Error(`unexpected package names`, e.PackageNames())
+	}
+	e, err = p.Read()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.Path != "etc/sudoers" || !reflect.DeepEqual(e.PackageNames(), []string{"sudo", "sudo-ldap"}) {
+		t.Error(`unexpected entry`, e)
+	}
+	if _, err := p.Read(); err != io.EOF {
+		t.Error(`err != io.EOF`, err)
+	}
+
+	const old = `This file maps each file available in the Ubuntu
+system to the package from which it originates.
+
+FILE                                                    LOCATION
+bin/bash                                                shells/bash
+`
+	p = NewContentsParser(strings.NewReader(old))
+	e, err = p.Read()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.Path != "bin/bash" || !reflect.DeepEqual(e.Packages, []string{"shells/bash"}) {
+		t.Error(`unexpected entry`, e)
+	}
+	if _, err := p.Read(); err != io.EOF {
+		t.Error(`err != io.EOF`, err)
+	}
+
+	p = NewContentsParser(strings.NewReader("no-location\n"))
+	if _, err := p.Read(); err == nil {
+		t.Error(`invalid line must be rejected`)
+	}
+}
@@ -0,0 +1,191 @@
+package apt
+
+// This file implements order-preserving paragraphs and a writer
+// for debian control files.
+//
+// The specification is:
+// https://www.debian.org/doc/debian-policy/ch-controlfields.html
+
+import (
+	"bytes"
+	"io"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Field is a field of a control file paragraph.
+type Field struct {
+	// Name is the field name in its original case.
+	Name string
+
+	// Value is the field value without leading whitespace.
+	//
+	// Continuation lines of multiline or folded fields follow the
+	// first line separated by "\n", keeping their leading whitespace.
+	// For example, the value of "MD5Sum" field in Release starts
+	// with an empty line.
+	Value string
+
+	// sep is the original whitespace between the colon and the value.
+	sep    string
+	hasSep bool
+}
+
+// Lines returns the value split into lines with surrounding
+// whitespace removed.  The first line is omitted if empty.
+//
+// This is the same as values of Paragraph.
+func (f *Field) Lines() []string {
+	var lines []string
+	l := strings.Split(f.Value, "\n")
+	if v := strings.Trim(l[0], " \t"); len(v) > 0 {
+		lines = append(lines, v)
+	}
+	for _, v := range l[1:] {
+		lines = append(lines, strings.Trim(v, " \t"))
+	}
+	return lines
+}
+
+// OrderedParagraph is a paragraph that keeps the order and the
+// original text of fields.  Field names are matched case-insensitively.
+//
+// Comments are not kept.
+type OrderedParagraph struct {
+	Fields []*Field
+}
+
+// Field returns the first field named name, or nil if not found.
+func (p *OrderedParagraph) Field(name string) *Field {
+	for _, f := range p.Fields {
+		if strings.EqualFold(f.Name, name) {
+			return f
+		}
+	}
+	return nil
+}
+
+// Get returns the value of the field named name.
+func (p *OrderedParagraph) Get(name string) (string, bool) {
+	f := p.Field(name)
+	if f == nil {
+		return "", false
+	}
+	return f.Value, true
+}
+
+// Set sets the value of the field named name.
+//
+// If the field exists, its value is replaced keeping the position
+// and the spelling of the name.  Otherwise, a new field is appended.
+func (p *OrderedParagraph) Set(name, value string) {
+	if f := p.Field(name); f != nil {
+		f.Value = value
+		f.sep = ""
+		f.hasSep = false
+		return
+	}
+	p.Fields = append(p.Fields, &Field{Name: name, Value: value})
+}
+
+// Delete removes fields named name.
+func (p *OrderedParagraph) Delete(name string) {
+	fields := p.Fields[:0]
+	for _, f := range p.Fields {
+		if !strings.EqualFold(f.Name, name) {
+			fields = append(fields, f)
+		}
+	}
+	for i := len(fields); i < len(p.Fields); i++ {
+		p.Fields[i] = nil
+	}
+	p.Fields = fields
+}
+
+// Paragraph converts p into Paragraph.
+func (p *OrderedParagraph) Paragraph() Paragraph {
+	d := make(Paragraph)
+	for _, f := range p.Fields {
+		if lines := f.Lines(); len(lines) > 0 {
+			d[f.Name] = append(d[f.Name], lines...)
+		}
+	}
+	return d
+}
+
+func validFieldName(name string) bool {
+	if len(name) == 0 || name[0] == '#' || name[0] == '-' {
+		return false
+	}
+	for i := 0; i < len(name); i++ {
+		if c := name[i]; c <= ' ' || c == ':' || c >= 0x7f {
+			return false
+		}
+	}
+	return true
+}
+
+// Writer writes paragraphs of a control file.
+//
+// Paragraphs read by Parser.ReadOrdered are written back byte by byte
+// unless they are modified.
+type Writer struct {
+	w io.Writer
+	n int
+}
+
+// NewWriter creates a writer to w.
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{w: w}
+}
+
+// WriteParagraph writes a paragraph.
+// Paragraphs are separated by an empty line.
+//
+// Continuation lines without leading whitespace are indented by
+// a space.  Empty continuation lines are errors, as they would
+// terminate the paragraph.
+func (w *Writer) WriteParagraph(p *OrderedParagraph) error {
+	if len(p.Fields) == 0 {
+		return errors.New("empty paragraph")
+	}
+
+	var buf bytes.Buffer
+	if w.n > 0 {
+		buf.WriteByte('\n')
+	}
+	for _, f := range p.Fields {
+		if !validFieldName(f.Name) {
+			return errors.New("invalid field name: " + f.Name)
+		}
+		lines := strings.Split(f.Value, "\n")
+
+		sep := f.sep
+		if !f.hasSep && len(lines[0]) > 0 {
+			sep = " "
+		}
+		buf.WriteString(f.Name)
+		buf.WriteByte(':')
+		buf.WriteString(sep)
+		buf.WriteString(lines[0])
+		buf.WriteByte('\n')
+
+		for _, l := range lines[1:] {
+			if len(strings.Trim(l, " \t")) == 0 {
+				return errors.New("empty continuation line in " + f.Name)
+			}
+			if l[0] != ' ' && l[0] != '\t' {
+				buf.WriteByte(' ')
+			}
+			buf.WriteString(l)
+			buf.WriteByte('\n')
+		}
+	}
+
+	if _, err := w.w.Write(buf.Bytes()); err != nil {
+		return err
+	}
+	w.n++
+	return nil
+}
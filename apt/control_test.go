@@ -0,0 +1,121 @@
+package apt
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"reflect"
+	"testing"
+)
+
+func testRoundTrip(t *testing.T, fn string) {
+	data, err := ioutil.ReadFile(fn)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	p := NewParser(bytes.NewReader(data))
+	w := NewWriter(&buf)
+	for {
+		op, err := p.ReadOrdered()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := w.WriteParagraph(op); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if !bytes.Equal(buf.Bytes(), data) {
+		t.Error(`written data differ from`, fn)
+	}
+}
+
+func TestControlRoundTrip(t *testing.T) {
+	t.Parallel()
+
+	for _, fn := range []string{
+		"testdata/af/Packages",
+		"testdata/af/Release",
+		"testdata/hash/Release",
+	} {
+		testRoundTrip(t, fn)
+	}
+}
+
+func TestOrderedParagraph(t *testing.T) {
+	t.Parallel()
+
+	const text = `Package: foo
+Version:  1.0
+MD5sum:
+ abc 1 a
+ def 2 b
+Description: short
+ long line
+ .
+ more
+`
+	op, err := NewParser(bytes.NewReader([]byte(text))).ReadOrdered()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(op.Fields) != 4 {
+		t.Fatal(`len(op.Fields) != 4`)
+	}
+
+	if v, ok := op.Get("md5SUM"); !ok || v != "\n abc 1 a\n def 2 b" {
+		t.Error(`unexpected MD5sum`, v)
+	}
+	if !reflect.DeepEqual(op.Field("Description").Lines(), []string{"short", "long line", ".", "more"}) {
+		t.Error(`unexpected lines`, op.Field("Description").Lines())
+	}
+
+	d := op.Paragraph()
+	if !reflect.DeepEqual(d["MD5sum"], []string{"abc 1 a", "def 2 b"}) {
+		t.Error(`unexpected paragraph`, d)
+	}
+
+	op.Set("version", "2.0")
+	op.Set("Architecture", "amd64")
+	op.Set("Depends", "libc6,\nlibfoo")
+	op.Delete("DESCRIPTION")
+
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	if err := w.WriteParagraph(op); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.WriteParagraph(&OrderedParagraph{Fields: []*Field{{Name: "Package", Value: "bar"}}}); err != nil {
+		t.Fatal(err)
+	}
+
+	const expected = `Package: foo
+Version: 2.0
+MD5sum:
+ abc 1 a
+ def 2 b
+Architecture: amd64
+Depends: libc6,
+ libfoo
+
+Package: bar
+`
+	if buf.String() != expected {
+		t.Error(`unexpected output`, buf.String())
+	}
+
+	for _, p := range []*OrderedParagraph{
+		{},
+		{Fields: []*Field{{Name: "Bad Name", Value: "a"}}},
+		{Fields: []*Field{{Name: "Description", Value: "a\n\nb"}}},
+	} {
+		if err := w.WriteParagraph(p); err == nil {
+			t.Error(`invalid paragraph must be rejected`, p)
+		}
+	}
+}
@@ -5,13 +5,59 @@ import (
 	"crypto/md5"
 	"crypto/sha1"
 	"crypto/sha256"
+	"crypto/sha512"
 	"encoding/hex"
 	"encoding/json"
+	"hash"
+	"io"
 	"path"
+	"strings"
 
 	"github.com/pkg/errors"
 )
 
+// ChecksumType represents a type of checksum algorithms.
+//
+// Larger values mean stronger algorithms.
+type ChecksumType int
+
+// Checksum types.
+const (
+	ChecksumNone ChecksumType = iota
+	ChecksumMD5
+	ChecksumSHA1
+	ChecksumSHA256
+	ChecksumSHA512
+)
+
+var checksumNames = map[ChecksumType]string{
+	ChecksumNone:   "none",
+	ChecksumMD5:    "md5",
+	ChecksumSHA1:   "sha1",
+	ChecksumSHA256: "sha256",
+	ChecksumSHA512: "sha512",
+}
+
+// String implements fmt.Stringer.
+func (ct ChecksumType) String() string {
+	return checksumNames[ct]
+}
+
+// ParseChecksumType parses a name of checksum algorithm such as "sha256".
+//
+// An empty string is parsed as ChecksumNone.
+func ParseChecksumType(s string) (ChecksumType, error) {
+	if len(s) == 0 {
+		return ChecksumNone, nil
+	}
+	for ct, name := range checksumNames {
+		if name == strings.ToLower(s) {
+			return ct, nil
+		}
+	}
+	return ChecksumNone, errors.New("unknown checksum type: " + s)
+}
+
 // FileInfo is a set of meta data of a file.
 type FileInfo struct {
 	path      string
@@ -19,6 +65,10 @@ type FileInfo struct {
 	md5sum    []byte // nil means no MD5 checksum to be checked.
 	sha1sum   []byte // nil means no SHA1 ...
 	sha256sum []byte // nil means no SHA256 ...
+	sha512sum []byte // nil means no SHA512 ...
+
+	// noSize means the size is unknown and not to be checked.
+	noSize bool
 }
 
 // Same returns true if t has the same checksum values.
@@ -29,7 +79,7 @@ func (fi *FileInfo) Same(t *FileInfo) bool {
 	if fi.path != t.path {
 		return false
 	}
-	if fi.size != t.size {
+	if !fi.noSize && fi.size != t.size {
 		return false
 	}
 	if fi.md5sum != nil && bytes.Compare(fi.md5sum, t.md5sum) != 0 {
@@ -41,6 +91,9 @@ func (fi *FileInfo) Same(t *FileInfo) bool {
 	if fi.sha256sum != nil && bytes.Compare(fi.sha256sum, t.sha256sum) != 0 {
 		return false
 	}
+	if fi.sha512sum != nil && bytes.Compare(fi.sha512sum, t.sha512sum) != 0 {
+		return false
+	}
 	return true
 }
 
@@ -54,20 +107,64 @@ func (fi *FileInfo) Size() uint64 {
 	return fi.size
 }
 
+// HasSize returns true if fi has the size of the file.
+//
+// FileInfo made from checksum lists such as SHA256SUMS do not
+// have sizes.
+func (fi *FileInfo) HasSize() bool {
+	return !fi.noSize
+}
+
 // HasChecksum returns true if fi has checksums.
 func (fi *FileInfo) HasChecksum() bool {
 	return fi.md5sum != nil
 }
 
+// StrongestChecksum returns the strongest type of checksums in fi.
+//
+// If fi has no checksums, ChecksumNone is returned.
+func (fi *FileInfo) StrongestChecksum() ChecksumType {
+	switch {
+	case len(fi.sha512sum) > 0:
+		return ChecksumSHA512
+	case len(fi.sha256sum) > 0:
+		return ChecksumSHA256
+	case len(fi.sha1sum) > 0:
+		return ChecksumSHA1
+	case len(fi.md5sum) > 0:
+		return ChecksumMD5
+	}
+	return ChecksumNone
+}
+
+// Checksum returns the checksum of type ct, or nil if fi does not have it.
+//
+// The returned slice must not be modified.
+func (fi *FileInfo) Checksum(ct ChecksumType) []byte {
+	switch ct {
+	case ChecksumMD5:
+		return fi.md5sum
+	case ChecksumSHA1:
+		return fi.sha1sum
+	case ChecksumSHA256:
+		return fi.sha256sum
+	case ChecksumSHA512:
+		return fi.sha512sum
+	}
+	return nil
+}
+
 // CalcChecksums calculates checksums and stores them in fi.
 func (fi *FileInfo) CalcChecksums(data []byte) {
 	md5sum := md5.Sum(data)
 	sha1sum := sha1.Sum(data)
 	sha256sum := sha256.Sum256(data)
+	sha512sum := sha512.Sum512(data)
 	fi.size = uint64(len(data))
 	fi.md5sum = md5sum[:]
 	fi.sha1sum = sha1sum[:]
 	fi.sha256sum = sha256sum[:]
+	fi.sha512sum = sha512sum[:]
 }
 
 // AddPrefix creates a new FileInfo by prepending prefix to the path.
@@ -113,12 +210,25 @@ func (fi *FileInfo) SHA256Path() string {
 		hex.EncodeToString(fi.sha256sum))
 }
 
+// SHA512Path returns the filepath for "by-hash" with sha512 checksum.
+// If fi has no checksum, an empty string will be returned.
+func (fi *FileInfo) SHA512Path() string {
+	if fi.sha512sum == nil {
+		return ""
+	}
+	return path.Join(path.Dir(fi.path),
+		"by-hash",
+		"SHA512",
+		hex.EncodeToString(fi.sha512sum))
+}
+
 type fileInfoJSON struct {
 	Path      string
 	Size      int64
 	MD5Sum    string
 	SHA1Sum   string
 	SHA256Sum string
+	SHA512Sum string `json:",omitempty"`
 }
 
 // MarshalJSON implements json.Marshaler
@@ -135,6 +245,9 @@ func (fi *FileInfo) MarshalJSON() ([]byte, error) {
 	if fi.sha256sum != nil {
 		fij.SHA256Sum = hex.EncodeToString(fi.sha256sum)
 	}
+	if fi.sha512sum != nil {
+		fij.SHA512Sum = hex.EncodeToString(fi.sha512sum)
+	}
 	return json.Marshal(&fij)
 }
 
@@ -161,6 +274,16 @@ func (fi *FileInfo) UnmarshalJSON(data []byte) error {
 	fi.md5sum = md5sum
 	fi.sha1sum = sha1sum
 	fi.sha256sum = sha256sum
+
+	// SHA512Sum may be missing in JSON generated by older versions.
+	fi.sha512sum = nil
+	if len(fij.SHA512Sum) > 0 {
+		sha512sum, err := hex.DecodeString(fij.SHA512Sum)
+		if err != nil {
+			return errors.Wrap(err, "UnmarshalJSON for "+fij.Path)
+		}
+		fi.sha512sum = sha512sum
+	}
 	return nil
 }
 
@@ -178,3 +301,60 @@ func MakeFileInfo(path string, data []byte) *FileInfo {
 	fi.CalcChecksums(data)
 	return fi
 }
+
+// MakeFileInfoFromReader constructs a FileInfo for data read from r
+// until EOF.  Unlike MakeFileInfo, data are not kept in memory.
+func MakeFileInfoFromReader(path string, r io.Reader) (*FileInfo, error) {
+	w := NewFileInfoWriter(path)
+	if _, err := io.Copy(w, r); err != nil {
+		return nil, err
+	}
+	return w.FileInfo(), nil
+}
+
+// FileInfoWriter is an io.Writer that calculates the size and
+// checksums of data written to it.
+//
+// This can be combined with io.TeeReader or io.MultiWriter to
+// calculate checksums while writing data to a file.
+type FileInfoWriter struct {
+	path      string
+	size      uint64
+	md5sum    hash.Hash
+	sha1sum   hash.Hash
+	sha256sum hash.Hash
+	sha512sum hash.Hash
+	w         io.Writer
+}
+
+// NewFileInfoWriter constructs a FileInfoWriter for a file at path.
+func NewFileInfoWriter(path string) *FileInfoWriter {
+	w := &FileInfoWriter{
+		path:      path,
+		md5sum:    md5.New(),
+		sha1sum:   sha1.New(),
+		sha256sum: sha256.New(),
+		sha512sum: sha512.New(),
+	}
+	w.w = io.MultiWriter(w.md5sum, w.sha1sum, w.sha256sum, w.sha512sum)
+	return w
+}
+
+// Write implements io.Writer.  This never returns an error.
+func (w *FileInfoWriter) Write(p []byte) (int, error) {
+	n, err := w.w.Write(p)
+	w.size += uint64(n)
+	return n, err
+}
+
+// FileInfo returns a FileInfo for data written so far.
+func (w *FileInfoWriter) FileInfo() *FileInfo {
+	return &FileInfo{
+		path:      w.path,
+		size:      w.size,
+		md5sum:    w.md5sum.Sum(nil),
+		sha1sum:   w.sha1sum.Sum(nil),
+		sha256sum: w.sha256sum.Sum(nil),
+		sha512sum: w.sha512sum.Sum(nil),
+	}
+}
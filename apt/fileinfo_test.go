@@ -5,6 +5,7 @@ import (
 	"crypto/md5"
 	"crypto/sha1"
 	"crypto/sha256"
+	"crypto/sha512"
 	"encoding/json"
 	"fmt"
 	"testing"
@@ -17,10 +18,12 @@ func testFileInfoSame(t *testing.T) {
 	md5sum := md5.Sum(data)
 	sha1sum := sha1.Sum(data)
 	sha256sum := sha256.Sum256(data)
+	sha512sum := sha512.Sum512(data)
 
 	data2 := []byte{'1', '2', '3'}
 	md5sum2 := md5.Sum(data2)
 	sha1sum2 := sha1.Sum(data2)
+	sha512sum2 := sha512.Sum512(data2)
 
 	fi := &FileInfo{
 		path:      "/data",
@@ -28,6 +31,7 @@ func testFileInfoSame(t *testing.T) {
 		md5sum:    md5sum[:],
 		sha1sum:   sha1sum[:],
 		sha256sum: sha256sum[:],
+		sha512sum: sha512sum[:],
 	}
 
 	if fi.Path() != "/data" {
@@ -116,6 +120,25 @@ func testFileInfoSame(t *testing.T) {
 	if !allmatch.Same(fi) {
 		t.Error(`!allmatch.Same(fi)`)
 	}
+
+	sha512mismatch := &FileInfo{
+		path:      "/data",
+		size:      uint64(len(data)),
+		sha256sum: sha256sum[:],
+		sha512sum: sha512sum2[:],
+	}
+	if sha512mismatch.Same(fi) {
+		t.Error(`sha512mismatch.Same(fi)`)
+	}
+
+	sha512match := &FileInfo{
+		path:      "/data",
+		size:      uint64(len(data)),
+		sha512sum: sha512sum[:],
+	}
+	if !sha512match.Same(fi) {
+		t.Error(`!sha512match.Same(fi)`)
+	}
 }
 
 func testFileInfoMake(t *testing.T) {
@@ -136,6 +159,10 @@ func testFileInfoMake(t *testing.T) {
 	if bytes.Compare(sha256sum[:], fi.sha256sum) != 0 {
 		t.Error(`bytes.Compare(sha256sum[:], fi.sha256sum) != 0`)
 	}
+	sha512sum := sha512.Sum512(data)
+	if bytes.Compare(sha512sum[:], fi.sha512sum) != 0 {
+		t.Error(`bytes.Compare(sha512sum[:], fi.sha512sum) != 0`)
+	}
 }
 
 func testFileInfoJSON(t *testing.T) {
@@ -160,6 +187,22 @@ func testFileInfoJSON(t *testing.T) {
 		t.Error(`!fi.Same(fi2)`)
 		t.Log(fmt.Sprintf("%#v", fi2))
 	}
+	if fi2.sha512sum == nil {
+		t.Error(`fi2.sha512sum == nil`)
+	}
+
+	// JSON generated by older versions lacks SHA512Sum.
+	fi3 := new(FileInfo)
+	err = json.Unmarshal([]byte(`{"Path":"/abc/def","Size":6,"MD5Sum":"e80b5017098950fc58aad83c8c14978e","SHA1Sum":"","SHA256Sum":""}`), fi3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi3.sha512sum != nil {
+		t.Error(`fi3.sha512sum != nil`)
+	}
+	if fi3.SHA512Path() != "" {
+		t.Error(`fi3.SHA512Path() != ""`)
+	}
 }
 
 func testFileInfoAddPrefix(t *testing.T) {
@@ -186,6 +229,7 @@ func testFileInfoChecksum(t *testing.T) {
 	md5 := "e80b5017098950fc58aad83c8c14978e"
 	s1 := "1f8ac10f23c5b5bc1167bda84b833e5c057a77d2"
 	s256 := "bef57ec7f53a6d40beb640a780a639c83bc29ac8a9816f1fc6c5c6dcd93c4721"
+	s512 := "e32ef19623e8ed9d267f657a81944b3d07adbb768518068e88435745564e8d4150a0a703be2a7d88b61e3d390c2bb97e2d4c311fdc69d6b1267f05f59aa920e7"
 
 	fi := MakeFileInfo(path, data)
 	if fi.MD5SumPath() != "/abc/by-hash/MD5Sum/"+md5 {
@@ -197,6 +241,94 @@ func testFileInfoChecksum(t *testing.T) {
 	if fi.SHA256Path() != "/abc/by-hash/SHA256/"+s256 {
 		t.Error(`fi.SHA256Path() != "/abc/by-hash/SHA256/" + s256`)
 	}
+	if fi.SHA512Path() != "/abc/by-hash/SHA512/"+s512 {
+		t.Error(`fi.SHA512Path() != "/abc/by-hash/SHA512/" + s512`)
+	}
+	if MakeFileInfoNoChecksum(path, 6).SHA512Path() != "" {
+		t.Error(`MakeFileInfoNoChecksum(path, 6).SHA512Path() != ""`)
+	}
+}
+
+func testFileInfoStrongestChecksum(t *testing.T) {
+	t.Parallel()
+
+	data := []byte{'a', 'b', 'c', 'd', 'e', 'f'}
+	md5sum := md5.Sum(data)
+	sha1sum := sha1.Sum(data)
+
+	fi := MakeFileInfo("/abc/def", data)
+	if fi.StrongestChecksum() != ChecksumSHA512 {
+		t.Error(`fi.StrongestChecksum() != ChecksumSHA512`)
+	}
+
+	fi = &FileInfo{
+		path:    "/abc/def",
+		size:    uint64(len(data)),
+		md5sum:  md5sum[:],
+		sha1sum: sha1sum[:],
+	}
+	if fi.StrongestChecksum() != ChecksumSHA1 {
+		t.Error(`fi.StrongestChecksum() != ChecksumSHA1`)
+	}
+
+	fi = MakeFileInfoNoChecksum("/abc/def", 6)
+	if fi.StrongestChecksum() != ChecksumNone {
+		t.Error(`fi.StrongestChecksum() != ChecksumNone`)
+	}
+
+	ct, err := ParseChecksumType("SHA256")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ct != ChecksumSHA256 {
+		t.Error(`ct != ChecksumSHA256`)
+	}
+	if ct.String() != "sha256" {
+		t.Error(`ct.String() != "sha256"`)
+	}
+	ct, err = ParseChecksumType("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ct != ChecksumNone {
+		t.Error(`ct != ChecksumNone`)
+	}
+	_, err = ParseChecksumType("crc32")
+	if err == nil {
+		t.Error(`ParseChecksumType("crc32") must fail`)
+	}
+}
+
+func testFileInfoReader(t *testing.T) {
+	t.Parallel()
+
+	data := bytes.Repeat([]byte("abcdef"), 100000)
+	fi := MakeFileInfo("/abc/def", data)
+
+	fi2, err := MakeFileInfoFromReader("/abc/def", bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !fi.Same(fi2) {
+		t.Error(`!fi.Same(fi2)`)
+	}
+	if !bytes.Equal(fi.sha512sum, fi2.sha512sum) {
+		t.Error(`!bytes.Equal(fi.sha512sum, fi2.sha512sum)`)
+	}
+
+	w := NewFileInfoWriter("/abc/def")
+	for i := 0; i < len(data); i += 4096 {
+		end := i + 4096
+		if end > len(data) {
+			end = len(data)
+		}
+		if _, err := w.Write(data[i:end]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !fi.Same(w.FileInfo()) {
+		t.Error(`!fi.Same(w.FileInfo())`)
+	}
 }
 
 func TestFileInfo(t *testing.T) {
@@ -205,4 +337,6 @@ func TestFileInfo(t *testing.T) {
 	t.Run("JSON", testFileInfoJSON)
 	t.Run("AddPrefix", testFileInfoAddPrefix)
 	t.Run("Checksum", testFileInfoChecksum)
+	t.Run("StrongestChecksum", testFileInfoStrongestChecksum)
+	t.Run("Reader", testFileInfoReader)
 }
@@ -0,0 +1,184 @@
+package apt
+
+// This file implements a decompressor for lzip (.lz) files.
+//
+// The specification of the format is:
+// http://www.nongnu.org/lzip/manual/lzip_manual.html#File-format
+//
+// An lzip file is a sequence of members.  A member consists of a
+// 6-byte header, a raw LZMA stream terminated by an end-of-stream
+// marker, and a 20-byte trailer.  The LZMA stream is decoded by
+// github.com/ulikunitz/xz/lzma by prepending a classic .lzma header
+// to it.
+//
+// Checksums in Release cover only the compressed data, so the trailer
+// of every member is checked, and data after the last member are
+// rejected.
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"hash"
+	"hash/crc32"
+	"io"
+
+	"github.com/pkg/errors"
+	"github.com/ulikunitz/xz/lzma"
+)
+
+const (
+	lzipHeaderLen  = 6
+	lzipTrailerLen = 20
+	lzipVersion    = 1
+
+	// lzip always uses these LZMA parameters: lc=3, lp=0, pb=2.
+	lzipProperties = (2*5+0)*9 + 3
+)
+
+var lzipMagic = []byte("LZIP")
+
+// lzipInput feeds an LZMA stream of a member to lzma.Reader.
+//
+// It reads the underlying data byte by byte so that the trailer
+// following the stream is not consumed by the LZMA decoder.
+type lzipInput struct {
+	br *bufio.Reader
+
+	// hdr is the classic .lzma header not yet read.
+	hdr []byte
+
+	// n is the number of bytes of the LZMA stream read so far.
+	n uint64
+}
+
+func (in *lzipInput) ReadByte() (byte, error) {
+	if len(in.hdr) > 0 {
+		c := in.hdr[0]
+		in.hdr = in.hdr[1:]
+		return c, nil
+	}
+	c, err := in.br.ReadByte()
+	if err == nil {
+		in.n++
+	}
+	return c, err
+}
+
+func (in *lzipInput) Read(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	c, err := in.ReadByte()
+	if err != nil {
+		return 0, err
+	}
+	p[0] = c
+	return 1, nil
+}
+
+// lzipReader decompresses all members of lzip data.
+type lzipReader struct {
+	in   *lzipInput
+	lr   *lzma.Reader
+	crc  hash.Hash32
+	size uint64
+	err  error
+}
+
+// newLzipReader returns an io.Reader that decompresses lzip data from r.
+func newLzipReader(r io.Reader) (io.Reader, error) {
+	z := &lzipReader{
+		in:  &lzipInput{br: bufio.NewReader(r)},
+		crc: crc32.NewIEEE(),
+	}
+	if err := z.startMember(true); err != nil {
+		return nil, err
+	}
+	return z, nil
+}
+
+// startMember reads the header of a member and prepares to decode it.
+func (z *lzipReader) startMember(first bool) error {
+	var h [lzipHeaderLen]byte
+	if _, err := io.ReadFull(z.in.br, h[:]); err != nil {
+		if !first {
+			return errors.New("lzip: trailing data after the last member")
+		}
+		return errors.Wrap(err, "lzip header")
+	}
+	if !bytes.Equal(h[0:4], lzipMagic) {
+		if !first {
+			return errors.New("lzip: trailing data after the last member")
+		}
+		return errors.New("lzip: bad magic")
+	}
+	if h[4] != lzipVersion {
+		return errors.Errorf("lzip: unsupported version %d", h[4])
+	}
+
+	dictSize := uint32(1) << (h[5] & 0x1f)
+	dictSize -= (dictSize / 16) * uint32((h[5]>>5)&0x07)
+
+	// classic .lzma header with unknown uncompressed size.
+	lh := make([]byte, lzma.HeaderLen)
+	lh[0] = lzipProperties
+	binary.LittleEndian.PutUint32(lh[1:5], dictSize)
+	binary.LittleEndian.PutUint64(lh[5:13], ^uint64(0))
+
+	z.in.hdr = lh
+	z.in.n = 0
+	z.crc.Reset()
+	z.size = 0
+
+	lr, err := lzma.NewReader(z.in)
+	if err != nil {
+		return errors.Wrap(err, "lzip")
+	}
+	z.lr = lr
+	return nil
+}
+
+// endMember checks the trailer of the current member, and starts
+// the next member if any.  This returns io.EOF at the end of data.
+func (z *lzipReader) endMember() error {
+	var t [lzipTrailerLen]byte
+	if _, err := io.ReadFull(z.in.br, t[:]); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return errors.Wrap(err, "lzip trailer")
+	}
+	if binary.LittleEndian.Uint32(t[0:4]) != z.crc.Sum32() {
+		return errors.New("lzip: CRC mismatch")
+	}
+	if binary.LittleEndian.Uint64(t[4:12]) != z.size {
+		return errors.New("lzip: data size mismatch")
+	}
+	if binary.LittleEndian.Uint64(t[12:20]) != lzipHeaderLen+z.in.n+lzipTrailerLen {
+		return errors.New("lzip: member size mismatch")
+	}
+
+	if _, err := z.in.br.Peek(1); err != nil {
+		return err
+	}
+	return z.startMember(false)
+}
+
+func (z *lzipReader) Read(p []byte) (int, error) {
+	for z.err == nil {
+		n, err := z.lr.Read(p)
+		z.crc.Write(p[:n])
+		z.size += uint64(n)
+		switch {
+		case err == io.EOF:
+			z.err = z.endMember()
+		case err != nil:
+			z.err = errors.Wrap(err, "lzip")
+		}
+		if n > 0 || len(p) == 0 {
+			return n, nil
+		}
+	}
+	return 0, z.err
+}
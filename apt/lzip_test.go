@@ -0,0 +1,69 @@
+package apt
+
+import (
+	"bytes"
+	"io/ioutil"
+	"testing"
+)
+
+func TestLzipReader(t *testing.T) {
+	t.Parallel()
+
+	lz, err := ioutil.ReadFile("testdata/af/Packages.lz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	orig, err := ioutil.ReadFile("testdata/af/Packages")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	read := func(data []byte) ([]byte, error) {
+		r, err := newLzipReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		return ioutil.ReadAll(r)
+	}
+
+	data, err := read(lz)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, orig) {
+		t.Error(`unexpected data`)
+	}
+
+	// multi-member files are concatenations of members.
+	multi := append(append([]byte{}, lz...), lz...)
+	data, err = read(multi)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, append(append([]byte{}, orig...), orig...)) {
+		t.Error(`all members must be decoded`, len(data))
+	}
+
+	if _, err := read(append(append([]byte{}, lz...), "garbage"...)); err == nil {
+		t.Error(`trailing data must be rejected`)
+	}
+	if _, err := read(append(append([]byte{}, lz...), "LZ"...)); err == nil {
+		t.Error(`truncated member header must be rejected`)
+	}
+
+	badCRC := append([]byte{}, lz...)
+	badCRC[len(badCRC)-20] ^= 0xff
+	if _, err := read(badCRC); err == nil {
+		t.Error(`CRC mismatch must be detected`)
+	}
+
+	badSize := append([]byte{}, lz...)
+	badSize[len(badSize)-1] ^= 0x01
+	if _, err := read(badSize); err == nil {
+		t.Error(`member size mismatch must be detected`)
+	}
+
+	if _, err := read(lz[:len(lz)-10]); err == nil {
+		t.Error(`truncated trailer must be rejected`)
+	}
+}
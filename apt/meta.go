@@ -7,11 +7,15 @@ import (
 	"compress/gzip"
 	"encoding/hex"
 	"io"
+	"io/ioutil"
 	"path"
 	"strconv"
 	"strings"
 
+	"github.com/klauspost/compress/zstd"
 	"github.com/pkg/errors"
+	"github.com/ulikunitz/xz"
+	"github.com/ulikunitz/xz/lzma"
 )
 
 // IsMeta returns true if p points a debian repository index file
@@ -31,6 +35,8 @@ func IsMeta(p string) bool {
 		base = base[0 : len(base)-5]
 	case strings.HasSuffix(base, ".lz"):
 		base = base[0 : len(base)-3]
+	case strings.HasSuffix(base, ".zst"):
+		base = base[0 : len(base)-4]
 	}
 
 	switch base {
@@ -47,7 +53,7 @@ func IsMeta(p string) bool {
 // decompressed by ExtractFileInfo.
 func IsSupported(p string) bool {
 	switch path.Ext(p) {
-	case "", ".gz", ".bz2", ".gpg":
+	case "", ".gz", ".bz2", ".gpg", ".xz", ".lzma", ".lz", ".zst":
 		return true
 	}
 	return false
@@ -57,7 +63,7 @@ func IsSupported(p string) bool {
 // support for indices acquisition via hash values (by-hash).
 // See https://wiki.debian.org/DebianRepository/Format#indices_acquisition_via_hashsums_.28by-hash.29
 func SupportByHash(d Paragraph) bool {
-	p := d["Acquire-By-Hash"]
+	p, _ := d.Get("Acquire-By-Hash")
 	if len(p) != 1 {
 		return false
 	}
@@ -87,19 +93,34 @@ func parseChecksum(l string) (p string, size uint64, csum []byte, err error) {
 // getFilesFromRelease parses Release or InRelease file and
 // returns a list of *FileInfo pointed in the file.
 func getFilesFromRelease(p string, r io.Reader) ([]*FileInfo, Paragraph, error) {
-	dir := path.Dir(p)
-
 	d, err := NewParser(r).Read()
 	if err != nil {
 		return nil, nil, errors.Wrap(err, "NewParser(r).Read()")
 	}
 
-	md5sums := d["MD5Sum"]
-	sha1sums := d["SHA1"]
-	sha256sums := d["SHA256"]
+	fil, err := FileInfoFromRelease(p, d)
+	if err != nil {
+		return nil, nil, err
+	}
+	return fil, d, nil
+}
+
+// FileInfoFromRelease returns a list of *FileInfo listed in
+// a paragraph of Release or InRelease file.
+//
+// p is the relative path of the Release file.
+// This is useful to extract files from a paragraph returned by
+// Keyring.VerifyInRelease or Keyring.VerifyRelease.
+func FileInfoFromRelease(p string, d Paragraph) ([]*FileInfo, error) {
+	dir := path.Dir(p)
+
+	md5sums, _ := d.Get("MD5Sum")
+	sha1sums, _ := d.Get("SHA1")
+	sha256sums, _ := d.Get("SHA256")
+	sha512sums, _ := d.Get("SHA512")
 
-	if len(md5sums) == 0 && len(sha1sums) == 0 && len(sha256sums) == 0 {
-		return nil, d, nil
+	if len(md5sums) == 0 && len(sha1sums) == 0 && len(sha256sums) == 0 && len(sha512sums) == 0 {
+		return nil, nil
 	}
 
 	m := make(map[string]*FileInfo)
@@ -108,7 +129,7 @@ func getFilesFromRelease(p string, r io.Reader) ([]*FileInfo, Paragraph, error)
 		p, size, csum, err := parseChecksum(l)
 		p = path.Join(dir, path.Clean(p))
 		if err != nil {
-			return nil, nil, errors.Wrap(err, "parseChecksum for md5sums")
+			return nil, errors.Wrap(err, "parseChecksum for md5sums")
 		}
 
 		fi := &FileInfo{
@@ -123,7 +144,7 @@ func getFilesFromRelease(p string, r io.Reader) ([]*FileInfo, Paragraph, error)
 		p, size, csum, err := parseChecksum(l)
 		p = path.Join(dir, path.Clean(p))
 		if err != nil {
-			return nil, nil, errors.Wrap(err, "parseChecksum for sha1sums")
+			return nil, errors.Wrap(err, "parseChecksum for sha1sums")
 		}
 
 		fi, ok := m[p]
@@ -143,7 +164,7 @@ func getFilesFromRelease(p string, r io.Reader) ([]*FileInfo, Paragraph, error)
 		p, size, csum, err := parseChecksum(l)
 		p = path.Join(dir, path.Clean(p))
 		if err != nil {
-			return nil, nil, errors.Wrap(err, "parseChecksum for sha256sums")
+			return nil, errors.Wrap(err, "parseChecksum for sha256sums")
 		}
 
 		fi, ok := m[p]
@@ -159,6 +180,26 @@ func getFilesFromRelease(p string, r io.Reader) ([]*FileInfo, Paragraph, error)
 		}
 	}
 
+	for _, l := range sha512sums {
+		p, size, csum, err := parseChecksum(l)
+		p = path.Join(dir, path.Clean(p))
+		if err != nil {
+			return nil, errors.Wrap(err, "parseChecksum for sha512sums")
+		}
+
+		fi, ok := m[p]
+		if ok {
+			fi.sha512sum = csum
+		} else {
+			fi := &FileInfo{
+				path:      p,
+				size:      size,
+				sha512sum: csum,
+			}
+			m[p] = fi
+		}
+	}
+
 	// WORKAROUND: some (e.g. dell) repositories have invalid Release
 	// that contains wrong checksum for Release itself.  Ignore them.
 	delete(m, path.Join(dir, "Release"))
@@ -169,7 +210,7 @@ func getFilesFromRelease(p string, r io.Reader) ([]*FileInfo, Paragraph, error)
 	for _, fi := range m {
 		l = append(l, fi)
 	}
-	return l, d, nil
+	return l, nil
 }
 
 // getFilesFromPackages parses Packages file and returns
@@ -187,13 +228,13 @@ func getFilesFromPackages(p string, r io.Reader) ([]*FileInfo, Paragraph, error)
 			return nil, nil, errors.Wrap(err, "parser.Read")
 		}
 
-		filename, ok := d["Filename"]
+		filename, ok := d.Get("Filename")
 		if !ok {
 			return nil, nil, errors.New("no Filename in " + p)
 		}
 		fpath := path.Clean(filename[0])
 
-		strsize, ok := d["Size"]
+		strsize, ok := d.Get("Size")
 		if !ok {
 			return nil, nil, errors.New("no Size in " + p)
 		}
@@ -206,27 +247,34 @@ func getFilesFromPackages(p string, r io.Reader) ([]*FileInfo, Paragraph, error)
 			path: fpath,
 			size: size,
 		}
-		if csum, ok := d["MD5sum"]; ok {
+		if csum, ok := d.Get("MD5sum"); ok {
 			b, err := hex.DecodeString(csum[0])
 			if err != nil {
 				return nil, nil, err
 			}
 			fi.md5sum = b
 		}
-		if csum, ok := d["SHA1"]; ok {
+		if csum, ok := d.Get("SHA1"); ok {
 			b, err := hex.DecodeString(csum[0])
 			if err != nil {
 				return nil, nil, err
 			}
 			fi.sha1sum = b
 		}
-		if csum, ok := d["SHA256"]; ok {
+		if csum, ok := d.Get("SHA256"); ok {
 			b, err := hex.DecodeString(csum[0])
 			if err != nil {
 				return nil, nil, err
 			}
 			fi.sha256sum = b
 		}
+		if csum, ok := d.Get("SHA512"); ok {
+			b, err := hex.DecodeString(csum[0])
+			if err != nil {
+				return nil, nil, err
+			}
+			fi.sha512sum = b
+		}
 		l = append(l, fi)
 	}
 
@@ -248,11 +296,11 @@ func getFilesFromSources(p string, r io.Reader) ([]*FileInfo, Paragraph, error)
 			return nil, nil, errors.Wrap(err, "parser.Read")
 		}
 
-		dir, ok := d["Directory"]
+		dir, ok := d.Get("Directory")
 		if !ok {
 			return nil, nil, errors.New("no Directory in " + p)
 		}
-		files, ok := d["Files"]
+		files, ok := d.Get("Files")
 		if !ok {
 			return nil, nil, errors.New("no Files in " + p)
 		}
@@ -273,7 +321,8 @@ func getFilesFromSources(p string, r io.Reader) ([]*FileInfo, Paragraph, error)
 			m[fpath] = fi
 		}
 
-		for _, l := range d["Checksums-Sha1"] {
+		sha1s, _ := d.Get("Checksums-Sha1")
+		for _, l := range sha1s {
 			fname, _, csum, err := parseChecksum(l)
 			if err != nil {
 				return nil, nil, errors.Wrap(err, "parseChecksum for Checksums-Sha1")
@@ -287,7 +336,8 @@ func getFilesFromSources(p string, r io.Reader) ([]*FileInfo, Paragraph, error)
 			fi.sha1sum = csum
 		}
 
-		for _, l := range d["Checksums-Sha256"] {
+		sha256s, _ := d.Get("Checksums-Sha256")
+		for _, l := range sha256s {
 			fname, _, csum, err := parseChecksum(l)
 			if err != nil {
 				return nil, nil, errors.Wrap(err, "parseChecksum for Checksums-Sha256")
@@ -301,6 +351,21 @@ func getFilesFromSources(p string, r io.Reader) ([]*FileInfo, Paragraph, error)
 			fi.sha256sum = csum
 		}
 
+		sha512s, _ := d.Get("Checksums-Sha512")
+		for _, l := range sha512s {
+			fname, _, csum, err := parseChecksum(l)
+			if err != nil {
+				return nil, nil, errors.Wrap(err, "parseChecksum for Checksums-Sha512")
+			}
+
+			fpath := path.Clean(path.Join(dir[0], fname))
+			fi, ok := m[fpath]
+			if !ok {
+				return nil, nil, errors.New("mismatch between Files and Checksums-Sha512 in " + p)
+			}
+			fi.sha512sum = csum
+		}
+
 		for _, fi := range m {
 			l = append(l, fi)
 		}
@@ -315,6 +380,52 @@ func getFilesFromIndex(p string, r io.Reader) ([]*FileInfo, Paragraph, error) {
 	return getFilesFromRelease(p, r)
 }
 
+// Decompress returns a reader to decompress data read from r
+// by the algorithm indicated by the file extension of p.
+//
+// If p has no extension or ends with ".gpg", data are read as is.
+// Closing the returned reader does not close r.
+func Decompress(p string, r io.Reader) (io.ReadCloser, error) {
+	switch ext := path.Ext(path.Base(p)); ext {
+	case "", ".gpg":
+		return ioutil.NopCloser(r), nil
+	case ".gz":
+		gz, err := gzip.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		return gz, nil
+	case ".bz2":
+		return ioutil.NopCloser(bzip2.NewReader(r)), nil
+	case ".xz":
+		xzr, err := xz.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		return ioutil.NopCloser(xzr), nil
+	case ".lzma":
+		lzr, err := lzma.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		return ioutil.NopCloser(lzr), nil
+	case ".lz":
+		lzr, err := newLzipReader(r)
+		if err != nil {
+			return nil, err
+		}
+		return ioutil.NopCloser(lzr), nil
+	case ".zst":
+		zr, err := zstd.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		return zr.IOReadCloser(), nil
+	default:
+		return nil, errors.New("unsupported file extension: " + ext)
+	}
+}
+
 // ExtractFileInfo parses debian repository index files such as
 // Release, Packages, or Sources and return a list of *FileInfo
 // listed in the file.
@@ -328,24 +439,16 @@ func ExtractFileInfo(p string, r io.Reader) ([]*FileInfo, Paragraph, error) {
 		return nil, nil, errors.New("not a meta data file: " + p)
 	}
 
+	dr, err := Decompress(p, r)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer dr.Close()
+	r = dr
+
 	base := path.Base(p)
-	ext := path.Ext(base)
-	switch ext {
-	case "", ".gpg":
-		// do nothing
-	case ".gz":
-		gz, err := gzip.NewReader(r)
-		if err != nil {
-			return nil, nil, err
-		}
-		defer gz.Close()
-		r = gz
-		base = base[:len(base)-3]
-	case ".bz2":
-		r = bzip2.NewReader(r)
-		base = base[:len(base)-4]
-	default:
-		return nil, nil, errors.New("unsupported file extension: " + ext)
+	if ext := path.Ext(base); ext != ".gpg" {
+		base = base[:len(base)-len(ext)]
 	}
 
 	switch base {
@@ -3,6 +3,7 @@ package apt
 import (
 	"encoding/hex"
 	"os"
+	"strings"
 	"testing"
 )
 
@@ -34,6 +35,12 @@ func TestIsMeta(t *testing.T) {
 	if !IsMeta("Packages.xz") {
 		t.Error(`!IsMeta("Packages.xz")`)
 	}
+	if !IsMeta("Packages.zst") {
+		t.Error(`!IsMeta("Packages.zst")`)
+	}
+	if IsMeta("Contents-amd64.zst") {
+		t.Error(`IsMeta("Contents-amd64.zst")`)
+	}
 	if IsMeta("Packages.gz.xz") {
 		t.Error(`IsMeta("Packages.gz.xz")`)
 	}
@@ -45,6 +52,36 @@ func TestIsMeta(t *testing.T) {
 	}
 }
 
+func TestIsSupported(t *testing.T) {
+	if !IsSupported("Packages") {
+		t.Error(`!IsSupported("Packages")`)
+	}
+	if !IsSupported("Packages.gz") {
+		t.Error(`!IsSupported("Packages.gz")`)
+	}
+	if !IsSupported("Packages.bz2") {
+		t.Error(`!IsSupported("Packages.bz2")`)
+	}
+	if !IsSupported("Packages.xz") {
+		t.Error(`!IsSupported("Packages.xz")`)
+	}
+	if !IsSupported("Packages.lzma") {
+		t.Error(`!IsSupported("Packages.lzma")`)
+	}
+	if !IsSupported("Packages.lz") {
+		t.Error(`!IsSupported("Packages.lz")`)
+	}
+	if !IsSupported("Packages.zst") {
+		t.Error(`!IsSupported("Packages.zst")`)
+	}
+	if !IsSupported("Release.gpg") {
+		t.Error(`!IsSupported("Release.gpg")`)
+	}
+	if IsSupported("Packages.Z") {
+		t.Error(`IsSupported("Packages.Z")`)
+	}
+}
+
 func containsFileInfo(fi *FileInfo, l []*FileInfo) bool {
 	for _, fi2 := range l {
 		if fi.Same(fi2) {
@@ -122,6 +159,35 @@ func TestGetFilesFromRelease(t *testing.T) {
 	}
 }
 
+func TestFileInfoFromRelease(t *testing.T) {
+	t.Parallel()
+
+	d := Paragraph{
+		"SHA256": {
+			"e3b1e5a6951881bca3ee230e5f3215534eb07f602a2f0415af3b182468468104             3098 main/binary-all/Packages",
+		},
+		"SHA512": {
+			"cf83e1357eefb8bdf1542850d66d8007d620e4050b5715dc83f4a921d36ce9ce47d0d13c5d85f2b0ff8318d2877eec2f63b931bd47417a81a538327af927da3e             3098 main/binary-all/Packages",
+			"cf83e1357eefb8bdf1542850d66d8007d620e4050b5715dc83f4a921d36ce9ce47d0d13c5d85f2b0ff8318d2877eec2f63b931bd47417a81a538327af927da3e             1259 main/binary-all/Packages.bz2",
+		},
+	}
+	fil, err := FileInfoFromRelease("dists/testing/Release", d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(fil) != 2 {
+		t.Fatal(`len(fil) != 2`)
+	}
+	for _, fi := range fil {
+		if fi.sha512sum == nil {
+			t.Error(`fi.sha512sum == nil`, fi.Path())
+		}
+		if fi.Path() == "dists/testing/main/binary-all/Packages" && fi.sha256sum == nil {
+			t.Error(`fi.sha256sum == nil`)
+		}
+	}
+}
+
 func TestGetFilesFromPackages(t *testing.T) {
 	t.Parallel()
 
@@ -164,6 +230,33 @@ func TestGetFilesFromPackages(t *testing.T) {
 	}
 }
 
+func TestGetFilesFromCompressedPackages(t *testing.T) {
+	t.Parallel()
+
+	for _, ext := range []string{".xz", ".lzma", ".lz", ".zst"} {
+		f, err := os.Open("testdata/af/Packages" + ext)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		fil, _, err := ExtractFileInfo("ubuntu/dists/testing/main/binary-amd64/Packages"+ext, f)
+		f.Close()
+		if err != nil {
+			t.Fatal(ext, err)
+		}
+		if len(fil) != 2 {
+			t.Error(ext, `len(fil) != 2`)
+			continue
+		}
+		if fil[0].Path() != "pool/c/cybozu-abc_0.2.2-1_amd64.deb" {
+			t.Error(ext, `fil[0].Path() != "pool/c/cybozu-abc_0.2.2-1_amd64.deb"`)
+		}
+		if fil[1].Size() != 1018650 {
+			t.Error(ext, `fil[1].Size() != 1018650`)
+		}
+	}
+}
+
 func TestGetFilesFromSources(t *testing.T) {
 	t.Parallel()
 
@@ -293,3 +386,40 @@ func TestExtractFileInfo(t *testing.T) {
 		t.Error(`len(fil) != 0`)
 	}
 }
+
+func TestExtractFileInfoCaseInsensitive(t *testing.T) {
+	t.Parallel()
+
+	const md5 = "d41d8cd98f00b204e9800998ecf8427e"
+	const sha256 = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+	fil, _, err := ExtractFileInfo("dists/a/main/binary-amd64/Packages", strings.NewReader(
+		"Package: a\nfilename: pool/a.deb\nSIZE: 0\nMd5sum: "+md5+"\nsha256: "+sha256+"\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(fil) != 1 || fil[0].md5sum == nil || fil[0].sha256sum == nil {
+		t.Error(`checksums in Packages must be found`)
+	}
+
+	fil, d, err := ExtractFileInfo("dists/a/Release", strings.NewReader(
+		"acquire-by-hash: yes\nmd5sum:\n "+md5+" 0 main/binary-amd64/Packages\nSha256:\n "+sha256+" 0 main/binary-amd64/Packages\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(fil) != 1 || fil[0].md5sum == nil || fil[0].sha256sum == nil {
+		t.Error(`checksums in Release must be found`)
+	}
+	if !SupportByHash(d) {
+		t.Error(`!SupportByHash(d)`)
+	}
+
+	fil, _, err = ExtractFileInfo("dists/a/main/source/Sources", strings.NewReader(
+		"Package: a\ndirectory: pool/a\nfiles:\n "+md5+" 0 a.dsc\nchecksums-SHA256:\n "+sha256+" 0 a.dsc\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(fil) != 1 || fil[0].md5sum == nil || fil[0].sha256sum == nil {
+		t.Error(`checksums in Sources must be found`)
+	}
+}
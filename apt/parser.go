@@ -12,9 +12,24 @@ package apt
 
 import (
 	"bufio"
-	"errors"
 	"io"
 	"strings"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// DefaultMaxLineSize is the maximum length of a line accepted by
+	// parsers created by NewParser.
+	DefaultMaxLineSize = 16 << 20
+
+	initialBufferSize = 64 * 1024
+)
+
+var (
+	// ErrLineTooLong is the cause of errors returned by Parser
+	// when a line exceeds the maximum length.
+	ErrLineTooLong = errors.New("line too long")
 )
 
 // Paragraph is a mapping between field names and values.
@@ -22,45 +37,126 @@ import (
 // Values are a list of strings.  For simple fields, the list has only
 // one element.  Newlines are stripped from (multiline) strings.
 // Folded fields are treated just the same as multiline fields.
+//
+// Keys are field names in their original spelling.  As field names
+// are case-insensitive, use Get or Value to look up fields.
 type Paragraph map[string][]string
 
+// Get returns values of the field named name.
+//
+// Field names are matched case-insensitively.  If the paragraph
+// has more than one field matching name, exactly matching one
+// is preferred.
+func (d Paragraph) Get(name string) ([]string, bool) {
+	if v, ok := d[name]; ok {
+		return v, true
+	}
+
+	// choose the smallest key for consistency
+	var found string
+	for k := range d {
+		if strings.EqualFold(k, name) && (len(found) == 0 || k < found) {
+			found = k
+		}
+	}
+	if len(found) == 0 {
+		return nil, false
+	}
+	return d[found], true
+}
+
+// Value returns the first value of the field named name, or
+// an empty string if not found.  Field names are matched
+// case-insensitively.
+func (d Paragraph) Value(name string) string {
+	v, _ := d.Get(name)
+	if len(v) == 0 {
+		return ""
+	}
+	return v[0]
+}
+
 // Parser reads debian control file and return Paragraph one by one.
 //
 // PGP preambles and signatures are ignored if any.
 type Parser struct {
-	s         *bufio.Scanner
-	lastField string
-	err       error
-	isPGP     bool
+	s       *bufio.Scanner
+	maxSize int
+	lineno  int
+	err     error
+	isPGP   bool
 }
 
 // NewParser creates a parser from a io.Reader.
+//
+// Lines longer than DefaultMaxLineSize are rejected.
 func NewParser(r io.Reader) *Parser {
+	return NewParserSize(r, DefaultMaxLineSize)
+}
+
+// NewParserSize creates a parser that accepts lines up to maxSize
+// bytes, excluding the newline.  If maxSize is not positive,
+// DefaultMaxLineSize is used.
+func NewParserSize(r io.Reader, maxSize int) *Parser {
+	if maxSize <= 0 {
+		maxSize = DefaultMaxLineSize
+	}
+
+	s := bufio.NewScanner(r)
+	bufSize := initialBufferSize
+	if bufSize > maxSize+1 {
+		bufSize = maxSize + 1
+	}
+
+	// bufio.Scanner needs room for the newline.
+	s.Buffer(make([]byte, bufSize), maxSize+1)
 	return &Parser{
-		s:     bufio.NewScanner(r),
-		isPGP: false,
+		s:       s,
+		maxSize: maxSize,
+		isPGP:   false,
 	}
 }
 
-// Read reads a paragraph.
-//
-// It returns io.EOF if no more paragraph can be read.
-func (p *Parser) Read() (Paragraph, error) {
+// scan reads the next line.
+func (p *Parser) scan() bool {
+	if !p.s.Scan() {
+		return false
+	}
+	p.lineno++
+	return true
+}
+
+// scanErr returns the error of the underlying scanner.
+func (p *Parser) scanErr() error {
+	err := p.s.Err()
+	if err == bufio.ErrTooLong {
+		return errors.Wrapf(ErrLineTooLong, "line %d exceeds %d bytes", p.lineno+1, p.maxSize)
+	}
+	return err
+}
+
+// readFields reads fields of a paragraph.
+func (p *Parser) readFields() ([]*Field, error) {
 	if p.err != nil {
 		return nil, p.err
 	}
 
-	ret := make(Paragraph)
+	var fields []*Field
+	var last *Field
 L:
-	for p.s.Scan() {
+	for p.scan() {
 		switch l := p.s.Text(); {
 		case len(l) == 0:
+			if len(fields) == 0 {
+				// skip extra empty lines between paragraphs
+				continue
+			}
 			break L
 		case l[0] == '#':
 			continue
 		case l == "-----BEGIN PGP SIGNED MESSAGE-----":
 			p.isPGP = true
-			for p.s.Scan() {
+			for p.scan() {
 				if l2 := p.s.Text(); len(l2) == 0 {
 					break
 				}
@@ -68,39 +164,68 @@ L:
 			continue
 		case p.isPGP && l == "-----BEGIN PGP SIGNATURE-----":
 			// skip to EOF
-			for p.s.Scan() {
+			for p.scan() {
 			}
 			break L
 		case l[0] == ' ' || l[0] == '\t':
 			// multiline
-			if p.lastField == "" {
-				p.err = errors.New("invalid line: " + l)
+			if last == nil {
+				p.err = errors.Errorf("invalid line %d: %s", p.lineno, l)
 				return nil, p.err
 			}
-			ret[p.lastField] = append(ret[p.lastField], strings.Trim(l, " \t"))
+			last.Value += "\n" + l
 		case strings.ContainsRune(l, ':'):
 			t := strings.SplitN(l, ":", 2)
-			k := t[0]
-			v := strings.Trim(t[1], " \t")
-			p.lastField = k
-			if len(v) == 0 {
-				// ignore empty value field
-				continue
+			v := strings.TrimLeft(t[1], " \t")
+			last = &Field{
+				Name:   t[0],
+				Value:  v,
+				sep:    t[1][:len(t[1])-len(v)],
+				hasSep: true,
 			}
-			ret[k] = append(ret[k], v)
+			fields = append(fields, last)
 		default:
-			p.err = errors.New("invalid line: " + l)
+			p.err = errors.Errorf("invalid line %d: %s", p.lineno, l)
 			return nil, p.err
 		}
 	}
-	p.lastField = ""
-	if err := p.s.Err(); err != nil {
+	if err := p.scanErr(); err != nil {
 		p.err = err
-	} else if len(ret) == 0 {
+	} else if len(fields) == 0 {
 		p.err = io.EOF
 	}
 	if p.err != nil {
 		return nil, p.err
 	}
-	return ret, nil
+	return fields, nil
+}
+
+// Read reads a paragraph.
+//
+// It returns io.EOF if no more paragraph can be read.
+func (p *Parser) Read() (Paragraph, error) {
+	for {
+		fields, err := p.readFields()
+		if err != nil {
+			return nil, err
+		}
+
+		// paragraphs only with empty fields are ignored.
+		ret := (&OrderedParagraph{Fields: fields}).Paragraph()
+		if len(ret) > 0 {
+			return ret, nil
+		}
+	}
+}
+
+// ReadOrdered reads a paragraph keeping the order and the original
+// text of fields.
+//
+// It returns io.EOF if no more paragraph can be read.
+func (p *Parser) ReadOrdered() (*OrderedParagraph, error) {
+	fields, err := p.readFields()
+	if err != nil {
+		return nil, err
+	}
+	return &OrderedParagraph{Fields: fields}, nil
 }
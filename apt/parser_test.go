@@ -3,7 +3,10 @@ package apt
 import (
 	"io"
 	"os"
+	"strings"
 	"testing"
+
+	"github.com/pkg/errors"
 )
 
 func TestParserRelease(t *testing.T) {
@@ -173,3 +176,85 @@ func TestParserPackages(t *testing.T) {
 		t.Error(`err != io.EOF`)
 	}
 }
+
+func TestParserEmptyLines(t *testing.T) {
+	t.Parallel()
+
+	p := NewParser(strings.NewReader("\n\nPackage: a\n\n\n\nEmpty:\n\nPackage: b\n"))
+	for _, name := range []string{"a", "b"} {
+		d, err := p.Read()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if d["Package"][0] != name {
+			t.Error(`d["Package"][0] != name`, name)
+		}
+	}
+	_, err := p.Read()
+	if err != io.EOF {
+		t.Error(`err != io.EOF`)
+	}
+}
+
+func TestParserLongLine(t *testing.T) {
+	t.Parallel()
+
+	long := strings.Repeat("a", 100*1024)
+	data := "Package: a\nDescription: " + long + "\n\nPackage: b\n"
+
+	p := NewParser(strings.NewReader(data))
+	d, err := p.Read()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d["Description"][0] != long {
+		t.Error(`d["Description"][0] != long`)
+	}
+	d, err = p.Read()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d["Package"][0] != "b" {
+		t.Error(`d["Package"][0] != "b"`)
+	}
+
+	p = NewParserSize(strings.NewReader(data), 1024)
+	_, err = p.Read()
+	if errors.Cause(err) != ErrLineTooLong {
+		t.Error(`errors.Cause(err) != ErrLineTooLong`, err)
+	}
+	if !strings.Contains(err.Error(), "line 2") {
+		t.Error(`error should tell the line number`, err)
+	}
+
+	// a line of exactly the maximum length is accepted.
+	p = NewParserSize(strings.NewReader("Package: abc\n"), 12)
+	if _, err := p.Read(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestParagraphGet(t *testing.T) {
+	t.Parallel()
+
+	d := Paragraph{
+		"MD5sum":  {"a"},
+		"Md5Sum":  {"b"},
+		"Package": {"foo"},
+	}
+	if v, ok := d.Get("MD5sum"); !ok || v[0] != "a" {
+		t.Error(`exact match must be preferred`)
+	}
+	if v, ok := d.Get("md5sum"); !ok || v[0] != "a" {
+		t.Error(`d.Get("md5sum") != "a"`, v)
+	}
+	if d.Value("PACKAGE") != "foo" {
+		t.Error(`d.Value("PACKAGE") != "foo"`)
+	}
+	if _, ok := d.Get("Version"); ok {
+		t.Error(`d.Get("Version") must not be found`)
+	}
+	if d.Value("Version") != "" {
+		t.Error(`d.Value("Version") != ""`)
+	}
+}
@@ -0,0 +1,426 @@
+package apt
+
+// This file implements pdiffs, incremental updates of indices.
+//
+// The specification is:
+// https://wiki.debian.org/DebianRepository/Format#diff_Indices
+//
+// Patches are ed scripts generated by "diff --ed".  As with apt's rred,
+// only "a", "c", and "d" commands are supported.
+
+import (
+	"bytes"
+	"encoding/hex"
+	"io"
+	"io/ioutil"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	// ErrNoPatch is returned by DiffIndex.Select and DiffIndex.Apply
+	// when the local index is too old or unknown to be patched.
+	// In this case, the whole index needs to be downloaded.
+	ErrNoPatch = errors.New("no applicable patch")
+
+	diffChecksumTypes = []struct {
+		prefix string
+		ct     ChecksumType
+	}{
+		{"SHA1", ChecksumSHA1},
+		{"SHA256", ChecksumSHA256},
+		{"SHA512", ChecksumSHA512},
+	}
+)
+
+func setChecksum(fi *FileInfo, ct ChecksumType, csum []byte) {
+	switch ct {
+	case ChecksumMD5:
+		fi.md5sum = csum
+	case ChecksumSHA1:
+		fi.sha1sum = csum
+	case ChecksumSHA256:
+		fi.sha256sum = csum
+	case ChecksumSHA512:
+		fi.sha512sum = csum
+	}
+}
+
+// DiffPatch is a patch listed in a diff Index.
+type DiffPatch struct {
+	// Name is the name of the patch such as "2017-09-01-0215.07".
+	Name string
+
+	// History is the index to which the patch applies.
+	History *FileInfo
+
+	// Patch is the uncompressed patch.  This may be nil.
+	Patch *FileInfo
+
+	// Download is the compressed patch to be downloaded such as
+	// "dists/sid/main/binary-amd64/Packages.diff/2017-09-01-0215.07.gz".
+	// This may have no checksums if Patch has them.
+	Download *FileInfo
+}
+
+// DiffIndex is a parsed Index of pdiffs such as Packages.diff/Index.
+type DiffIndex struct {
+	// Current is the current uncompressed index.
+	Current *FileInfo
+
+	// Patches is the list of patches from the oldest to the newest.
+	Patches []*DiffPatch
+
+	// Merged is true if each patch updates its History directly
+	// to Current.  Otherwise, patches need to be applied in order.
+	Merged bool
+}
+
+// ParseDiffIndex parses a diff Index at p such as
+// "dists/sid/main/binary-amd64/Packages.diff/Index".
+//
+// The path of Current and History is that of the patched index,
+// e.g. "dists/sid/main/binary-amd64/Packages".
+func ParseDiffIndex(p string, r io.Reader) (*DiffIndex, error) {
+	d, err := NewParser(r).Read()
+	if err != nil {
+		return nil, errors.Wrap(err, p)
+	}
+
+	diffDir := path.Dir(p)
+	if path.Ext(diffDir) != ".diff" {
+		return nil, errors.New("not a diff Index: " + p)
+	}
+	indexPath := strings.TrimSuffix(diffDir, ".diff")
+
+	di := &DiffIndex{
+		Current: &FileInfo{path: indexPath},
+		Merged:  d.Value("X-Patch-Precedence") == "merged",
+	}
+	patches := make(map[string]*DiffPatch)
+	hasCurrent := false
+
+	for _, t := range diffChecksumTypes {
+		if v := d.Value(t.prefix + "-Current"); len(v) > 0 {
+			flds := strings.Fields(v)
+			if len(flds) != 2 {
+				return nil, errors.New("invalid " + t.prefix + "-Current in " + p)
+			}
+			size, err := strconv.ParseUint(flds[1], 10, 64)
+			if err != nil {
+				return nil, errors.Wrap(err, p)
+			}
+			csum, err := hexChecksum(flds[0], t.ct)
+			if err != nil {
+				return nil, errors.Wrap(err, p)
+			}
+			di.Current.size = size
+			setChecksum(di.Current, t.ct, csum)
+			hasCurrent = true
+		}
+
+		history, _ := d.Get(t.prefix + "-History")
+		for _, l := range history {
+			name, size, csum, err := parseDiffChecksum(l, t.ct)
+			if err != nil {
+				return nil, errors.Wrap(err, p)
+			}
+			dp, ok := patches[name]
+			if !ok {
+				dp = &DiffPatch{
+					Name:     name,
+					History:  &FileInfo{path: indexPath},
+					Download: &FileInfo{path: path.Join(diffDir, name+".gz"), noSize: true},
+				}
+				patches[name] = dp
+				di.Patches = append(di.Patches, dp)
+			}
+			dp.History.size = size
+			setChecksum(dp.History, t.ct, csum)
+		}
+
+		for _, suffix := range []string{"-Patches", "-Download"} {
+			lines, _ := d.Get(t.prefix + suffix)
+			for _, l := range lines {
+				name, size, csum, err := parseDiffChecksum(l, t.ct)
+				if err != nil {
+					return nil, errors.Wrap(err, p)
+				}
+				if suffix == "-Download" {
+					// names in Download have the compression extension.
+					ext := path.Ext(name)
+					dp, ok := patches[strings.TrimSuffix(name, ext)]
+					if !ok {
+						return nil, errors.New("no history for " + name + " in " + p)
+					}
+					if dp.Download.path != path.Join(diffDir, name) {
+						dp.Download = &FileInfo{path: path.Join(diffDir, name), noSize: true}
+					}
+					dp.Download.size = size
+					dp.Download.noSize = false
+					setChecksum(dp.Download, t.ct, csum)
+					continue
+				}
+
+				dp, ok := patches[name]
+				if !ok {
+					return nil, errors.New("no history for " + name + " in " + p)
+				}
+				if dp.Patch == nil {
+					dp.Patch = &FileInfo{path: path.Join(diffDir, name)}
+				}
+				dp.Patch.size = size
+				setChecksum(dp.Patch, t.ct, csum)
+			}
+		}
+	}
+
+	if !hasCurrent {
+		return nil, errors.New("no current checksum in " + p)
+	}
+	for _, dp := range di.Patches {
+		if dp.Patch == nil && dp.Download.StrongestChecksum() == ChecksumNone {
+			return nil, errors.New("no checksum for patch " + dp.Name + " in " + p)
+		}
+	}
+	return di, nil
+}
+
+func hexChecksum(s string, ct ChecksumType) ([]byte, error) {
+	csum, err := hex.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	if len(csum) != checksumSizes[ct] {
+		return nil, errors.New("invalid " + ct.String() + " checksum: " + s)
+	}
+	return csum, nil
+}
+
+func parseDiffChecksum(l string, ct ChecksumType) (string, uint64, []byte, error) {
+	name, size, csum, err := parseChecksum(l)
+	if err != nil {
+		return "", 0, nil, err
+	}
+	if len(csum) != checksumSizes[ct] {
+		return "", 0, nil, errors.New("invalid " + ct.String() + " checksum: " + l)
+	}
+	if strings.ContainsRune(name, '/') {
+		return "", 0, nil, errors.New("invalid patch name: " + name)
+	}
+	return name, size, csum, nil
+}
+
+// Select returns patches to be applied to local to make it current.
+//
+// If local is already current, this returns an empty list.
+// If no patch applies to local, ErrNoPatch is returned.
+func (di *DiffIndex) Select(local *FileInfo) ([]*DiffPatch, error) {
+	if di.Current.Same(local) {
+		return nil, nil
+	}
+
+	// look up from the newest as the same content may appear twice.
+	for i := len(di.Patches) - 1; i >= 0; i-- {
+		if !di.Patches[i].History.Same(local) {
+			continue
+		}
+		if di.Merged {
+			return di.Patches[i : i+1], nil
+		}
+		return di.Patches[i:], nil
+	}
+	return nil, ErrNoPatch
+}
+
+// Apply updates orig, the content of the local uncompressed index,
+// to the current one by patches.
+//
+// fetch is called to retrieve each compressed patch at dp.Download.
+// Patches are verified by the checksums in the Index, and the result
+// is verified by Current and by want, the FileInfo of the uncompressed
+// index listed in Release, if want is not nil.
+//
+// If no patch applies to orig, ErrNoPatch is returned.
+func (di *DiffIndex) Apply(orig []byte, want *FileInfo,
+	fetch func(dp *DiffPatch) (io.ReadCloser, error)) ([]byte, error) {
+
+	dpl, err := di.Select(MakeFileInfo(di.Current.path, orig))
+	if err != nil {
+		return nil, err
+	}
+
+	data := orig
+	for _, dp := range dpl {
+		patch, err := fetchPatch(dp, fetch)
+		if err != nil {
+			return nil, err
+		}
+		data, err = ApplyDiff(data, bytes.NewReader(patch))
+		if err != nil {
+			return nil, errors.Wrap(err, dp.Name)
+		}
+	}
+
+	fi := MakeFileInfo(di.Current.path, data)
+	if !di.Current.Same(fi) {
+		return nil, errors.New("patched index does not match the current checksum: " + fi.path)
+	}
+	if want != nil && !want.Same(fi) {
+		return nil, errors.New("patched index does not match the checksum in Release: " + fi.path)
+	}
+	return data, nil
+}
+
+// fetchPatch retrieves and verifies an uncompressed patch.
+func fetchPatch(dp *DiffPatch, fetch func(dp *DiffPatch) (io.ReadCloser, error)) ([]byte, error) {
+	rc, err := fetch(dp)
+	if err != nil {
+		return nil, err
+	}
+	compressed, err := ioutil.ReadAll(rc)
+	rc.Close()
+	if err != nil {
+		return nil, errors.Wrap(err, dp.Download.path)
+	}
+	if !dp.Download.Same(MakeFileInfo(dp.Download.path, compressed)) {
+		return nil, errors.New("invalid checksum for " + dp.Download.path)
+	}
+
+	r, err := Decompress(dp.Download.path, bytes.NewReader(compressed))
+	if err != nil {
+		return nil, errors.Wrap(err, dp.Download.path)
+	}
+	patch, err := ioutil.ReadAll(r)
+	r.Close()
+	if err != nil {
+		return nil, errors.Wrap(err, dp.Download.path)
+	}
+	if dp.Patch != nil && !dp.Patch.Same(MakeFileInfo(dp.Patch.path, patch)) {
+		return nil, errors.New("invalid checksum for " + dp.Patch.path)
+	}
+	return patch, nil
+}
+
+// edit is a command of ed scripts that replaces lines [lo, hi)
+// with text.  Line numbers are 0-based.
+type edit struct {
+	lo, hi int
+	text   [][]byte
+}
+
+// splitLines splits data into lines without newlines.
+func splitLines(data []byte) [][]byte {
+	if len(data) == 0 {
+		return nil
+	}
+	if data[len(data)-1] == '\n' {
+		data = data[:len(data)-1]
+	}
+	return bytes.Split(data, []byte{'\n'})
+}
+
+// parseEdits parses an ed script generated by "diff --ed".
+func parseEdits(patch io.Reader) ([]*edit, error) {
+	data, err := ioutil.ReadAll(patch)
+	if err != nil {
+		return nil, err
+	}
+	lines := splitLines(data)
+
+	var edits []*edit
+	for i := 0; i < len(lines); i++ {
+		l := string(lines[i])
+		if len(l) < 2 {
+			return nil, errors.Errorf("invalid command at line %d: %s", i+1, l)
+		}
+		cmd := l[len(l)-1]
+		addr := strings.SplitN(l[:len(l)-1], ",", 2)
+		first, err := strconv.Atoi(addr[0])
+		if err != nil || first < 0 {
+			return nil, errors.Errorf("invalid command at line %d: %s", i+1, l)
+		}
+		last := first
+		if len(addr) == 2 {
+			last, err = strconv.Atoi(addr[1])
+			if err != nil || last < first {
+				return nil, errors.Errorf("invalid command at line %d: %s", i+1, l)
+			}
+		}
+
+		e := new(edit)
+		switch cmd {
+		case 'a':
+			if len(addr) == 2 {
+				return nil, errors.Errorf("invalid command at line %d: %s", i+1, l)
+			}
+			e.lo, e.hi = first, first
+		case 'c', 'd':
+			if first == 0 {
+				return nil, errors.Errorf("invalid command at line %d: %s", i+1, l)
+			}
+			e.lo, e.hi = first-1, last
+		default:
+			return nil, errors.Errorf("unsupported command at line %d: %s", i+1, l)
+		}
+
+		if cmd != 'd' {
+			terminated := false
+			for i++; i < len(lines); i++ {
+				if len(lines[i]) == 1 && lines[i][0] == '.' {
+					terminated = true
+					break
+				}
+				e.text = append(e.text, lines[i])
+			}
+			if !terminated {
+				return nil, errors.New("unterminated text for " + l)
+			}
+		}
+		edits = append(edits, e)
+	}
+	return edits, nil
+}
+
+// ApplyDiff applies an ed script generated by "diff --ed" to orig
+// and returns the result.
+//
+// Commands must be in descending order of line numbers as generated
+// by diff.  orig is not modified.
+func ApplyDiff(orig []byte, patch io.Reader) ([]byte, error) {
+	edits, err := parseEdits(patch)
+	if err != nil {
+		return nil, err
+	}
+	lines := splitLines(orig)
+
+	for i, e := range edits {
+		if e.hi > len(lines) {
+			return nil, errors.Errorf("line %d is out of range", e.hi)
+		}
+		if i > 0 && e.hi > edits[i-1].lo {
+			return nil, errors.New("commands are not in descending order")
+		}
+	}
+
+	var buf bytes.Buffer
+	buf.Grow(len(orig))
+	writeLines := func(l [][]byte) {
+		for _, line := range l {
+			buf.Write(line)
+			buf.WriteByte('\n')
+		}
+	}
+	pos := 0
+	for i := len(edits) - 1; i >= 0; i-- {
+		e := edits[i]
+		writeLines(lines[pos:e.lo])
+		writeLines(e.text)
+		pos = e.hi
+	}
+	writeLines(lines[pos:])
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,230 @@
+package apt
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/pkg/errors"
+)
+
+func TestApplyDiff(t *testing.T) {
+	t.Parallel()
+
+	orig := []byte("a\nb\nc\nd\ne\n")
+	data, err := ApplyDiff(orig, strings.NewReader("5c\nE\n.\n3,4d\n1a\nX\nY\n.\n0a\nTOP\n.\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "TOP\na\nX\nY\nb\nE\n" {
+		t.Error(`unexpected result`, string(data))
+	}
+	if string(orig) != "a\nb\nc\nd\ne\n" {
+		t.Error(`orig must not be modified`)
+	}
+
+	data, err = ApplyDiff(orig, strings.NewReader(""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, orig) {
+		t.Error(`empty patch must not change data`)
+	}
+
+	for _, bad := range []string{
+		"1d\n3d\n",
+		"6d\n",
+		"0d\n",
+		"1a\nX\n",
+		"1,2a\nX\n.\n",
+		"s/.//\n",
+		"3,2d\n",
+	} {
+		_, err := ApplyDiff(orig, strings.NewReader(bad))
+		if err == nil {
+			t.Error(`invalid patch must be rejected:`, bad)
+		}
+	}
+}
+
+type testPatch struct {
+	name    string
+	history []byte
+	patch   string
+}
+
+func testGzip(t *testing.T, data []byte) []byte {
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	w.Write(data)
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func testDiffIndex(t *testing.T, current []byte, patches []testPatch, merged bool) (*DiffIndex, map[string][]byte) {
+	sha256sum := func(data []byte) string {
+		return hex.EncodeToString(MakeFileInfo("", data).Checksum(ChecksumSHA256))
+	}
+
+	files := make(map[string][]byte)
+	index := fmt.Sprintf("SHA256-Current: %s %d\n", sha256sum(current), len(current))
+	if merged {
+		index += "X-Patch-Precedence: merged\n"
+	}
+	history, plist, dlist := "SHA256-History:\n", "SHA256-Patches:\n", "SHA256-Download:\n"
+	for _, tp := range patches {
+		gz := testGzip(t, []byte(tp.patch))
+		files["main/binary-amd64/Packages.diff/"+tp.name+".gz"] = gz
+		history += fmt.Sprintf(" %s %d %s\n", sha256sum(tp.history), len(tp.history), tp.name)
+		plist += fmt.Sprintf(" %s %d %s\n", sha256sum([]byte(tp.patch)), len(tp.patch), tp.name)
+		dlist += fmt.Sprintf(" %s %d %s.gz\n", sha256sum(gz), len(gz), tp.name)
+	}
+	index += history + plist + dlist
+
+	di, err := ParseDiffIndex("main/binary-amd64/Packages.diff/Index", strings.NewReader(index))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return di, files
+}
+
+func TestDiffIndex(t *testing.T) {
+	t.Parallel()
+
+	v1 := []byte("Package: a\nVersion: 1\n\nPackage: b\nVersion: 1\n")
+	v2 := []byte("Package: a\nVersion: 2\n\nPackage: b\nVersion: 1\n")
+	v3 := []byte("Package: a\nVersion: 2\n\nPackage: c\nVersion: 1\n")
+
+	di, files := testDiffIndex(t, v3, []testPatch{
+		{"2017-09-01-0215.07", v1, "2c\nVersion: 2\n.\n"},
+		{"2017-09-01-0815.11", v2, "4c\nPackage: c\n.\n"},
+	}, false)
+	if di.Merged {
+		t.Error(`di.Merged`)
+	}
+	if di.Current.Path() != "main/binary-amd64/Packages" {
+		t.Error(`unexpected path`, di.Current.Path())
+	}
+	if len(di.Patches) != 2 {
+		t.Fatal(`len(di.Patches) != 2`, len(di.Patches))
+	}
+	if di.Patches[1].Download.Path() != "main/binary-amd64/Packages.diff/2017-09-01-0815.11.gz" {
+		t.Error(`unexpected path`, di.Patches[1].Download.Path())
+	}
+
+	var fetched []string
+	fetch := func(dp *DiffPatch) (io.ReadCloser, error) {
+		fetched = append(fetched, dp.Name)
+		data, ok := files[dp.Download.Path()]
+		if !ok {
+			return nil, errors.New("not found: " + dp.Download.Path())
+		}
+		return ioutil.NopCloser(bytes.NewReader(data)), nil
+	}
+
+	want := MakeFileInfo("main/binary-amd64/Packages", v3)
+	data, err := di.Apply(v1, want, fetch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, v3) {
+		t.Error(`unexpected result`, string(data))
+	}
+	if len(fetched) != 2 {
+		t.Error(`len(fetched) != 2`, fetched)
+	}
+
+	fetched = nil
+	data, err = di.Apply(v2, want, fetch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, v3) || len(fetched) != 1 {
+		t.Error(`v2 must be updated by the last patch`, fetched)
+	}
+
+	fetched = nil
+	data, err = di.Apply(v3, nil, fetch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, v3) || len(fetched) != 0 {
+		t.Error(`current index must not be patched`, fetched)
+	}
+
+	_, err = di.Apply([]byte("Package: x\n"), nil, fetch)
+	if errors.Cause(err) != ErrNoPatch {
+		t.Error(`errors.Cause(err) != ErrNoPatch`, err)
+	}
+
+	_, err = di.Apply(v1, MakeFileInfo("main/binary-amd64/Packages", v2), fetch)
+	if err == nil {
+		t.Error(`mismatch with Release must be an error`)
+	}
+
+	files["main/binary-amd64/Packages.diff/2017-09-01-0815.11.gz"] = testGzip(t, []byte("4d\n"))
+	_, err = di.Apply(v2, nil, fetch)
+	if err == nil {
+		t.Error(`broken patch must be rejected`)
+	}
+}
+
+func TestDiffIndexMerged(t *testing.T) {
+	t.Parallel()
+
+	v1 := []byte("Package: a\nVersion: 1\n\nPackage: b\nVersion: 1\n")
+	v2 := []byte("Package: a\nVersion: 2\n\nPackage: b\nVersion: 1\n")
+	v3 := []byte("Package: a\nVersion: 2\n\nPackage: c\nVersion: 1\n")
+
+	di, files := testDiffIndex(t, v3, []testPatch{
+		{"T-2017-09-01-0215.07-F-2017-09-01-0215.07", v1, "4c\nPackage: c\n.\n2c\nVersion: 2\n.\n"},
+		{"T-2017-09-01-0815.11-F-2017-09-01-0815.11", v2, "4c\nPackage: c\n.\n"},
+	}, true)
+	if !di.Merged {
+		t.Error(`!di.Merged`)
+	}
+
+	var fetched []string
+	fetch := func(dp *DiffPatch) (io.ReadCloser, error) {
+		fetched = append(fetched, dp.Name)
+		return ioutil.NopCloser(bytes.NewReader(files[dp.Download.Path()])), nil
+	}
+	data, err := di.Apply(v1, nil, fetch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, v3) {
+		t.Error(`unexpected result`, string(data))
+	}
+	if len(fetched) != 1 || fetched[0] != "T-2017-09-01-0215.07-F-2017-09-01-0215.07" {
+		t.Error(`only one merged patch must be applied`, fetched)
+	}
+}
+
+func TestParseDiffIndexInvalid(t *testing.T) {
+	t.Parallel()
+
+	for _, bad := range []string{
+		"SHA256-History:\n 0000 1 foo\n",
+		"SHA256-Current: 00 1\n",
+		"SHA1-Current: 0000000000000000000000000000000000000000 1\nSHA1-Patches:\n 0000000000000000000000000000000000000000 1 foo\n",
+		"SHA1-Current: 0000000000000000000000000000000000000000 1\nSHA1-History:\n 0000000000000000000000000000000000000000 1 ../foo\n",
+	} {
+		_, err := ParseDiffIndex("main/binary-amd64/Packages.diff/Index", strings.NewReader(bad))
+		if err == nil {
+			t.Error(`invalid Index must be rejected:`, bad)
+		}
+	}
+
+	_, err := ParseDiffIndex("main/binary-amd64/Index", strings.NewReader("SHA1-Current: 0000000000000000000000000000000000000000 1\n"))
+	if err == nil {
+		t.Error(`Index not in .diff directory must be rejected`)
+	}
+}
@@ -0,0 +1,199 @@
+package apt
+
+// This file implements parsing of relationship fields such as Depends.
+//
+// The specification is:
+// https://www.debian.org/doc/debian-policy/ch-relationships.html
+
+import (
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	// relation operators, longer ones first.
+	relationOps = []string{"<<", "<=", ">=", ">>", "=", "<", ">"}
+
+	// obsolete operators are normalized.
+	obsoleteOps = map[string]string{
+		"<": "<=",
+		">": ">=",
+	}
+)
+
+// Relation is a relation to a package in fields like Depends.
+type Relation struct {
+	Name string
+
+	// Arch is the architecture qualifier such as "any" in "python3:any",
+	// or empty if omitted.
+	Arch string
+
+	// Op is one of "<<", "<=", "=", ">=", ">>", or empty if the
+	// relation has no version constraint.
+	Op      string
+	Version *Version
+
+	// Architectures is the architecture restriction list such as
+	// [amd64 !i386] in Build-Depends.
+	Architectures []string
+
+	// Profiles is the list of restriction formulas such as
+	// <!nocheck> in Build-Depends.
+	Profiles [][]string
+}
+
+func validPackageName(s string) bool {
+	if len(s) == 0 {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if isDigit(c) || isLetter(c) || strings.IndexByte(".+-", c) >= 0 {
+			continue
+		}
+		return false
+	}
+	return true
+}
+
+// cutEnclosed returns the text enclosed by open and close at the
+// beginning of s, and the rest of s.
+func cutEnclosed(s string, open, close byte) (string, string, bool) {
+	if len(s) == 0 || s[0] != open {
+		return "", s, false
+	}
+	end := strings.IndexByte(s, close)
+	if end < 0 {
+		return "", s, false
+	}
+	return strings.TrimSpace(s[1:end]), strings.TrimSpace(s[end+1:]), true
+}
+
+// ParseRelation parses a relation such as "libc6 (>= 2.14)".
+func ParseRelation(s string) (*Relation, error) {
+	s = strings.TrimSpace(s)
+	name, rest := s, ""
+	if i := strings.IndexAny(s, " \t([<"); i >= 0 {
+		name, rest = s[:i], strings.TrimSpace(s[i:])
+	}
+
+	r := new(Relation)
+	if i := strings.IndexByte(name, ':'); i >= 0 {
+		name, r.Arch = name[:i], name[i+1:]
+		if len(r.Arch) == 0 {
+			return nil, errors.New("empty architecture qualifier: " + s)
+		}
+	}
+	if !validPackageName(name) {
+		return nil, errors.New("invalid package name: " + s)
+	}
+	r.Name = name
+
+	if c, next, ok := cutEnclosed(rest, '(', ')'); ok {
+		for _, op := range relationOps {
+			if strings.HasPrefix(c, op) {
+				r.Op = op
+				break
+			}
+		}
+		if len(r.Op) == 0 {
+			return nil, errors.New("invalid version constraint: " + s)
+		}
+		v, err := ParseVersion(c[len(r.Op):])
+		if err != nil {
+			return nil, errors.Wrap(err, s)
+		}
+		if op, ok := obsoleteOps[r.Op]; ok {
+			r.Op = op
+		}
+		r.Version = v
+		rest = next
+	}
+
+	if c, next, ok := cutEnclosed(rest, '[', ']'); ok {
+		r.Architectures = strings.Fields(c)
+		rest = next
+	}
+
+	for {
+		c, next, ok := cutEnclosed(rest, '<', '>')
+		if !ok {
+			break
+		}
+		r.Profiles = append(r.Profiles, strings.Fields(c))
+		rest = next
+	}
+
+	if len(rest) > 0 {
+		return nil, errors.New("invalid relation: " + s)
+	}
+	return r, nil
+}
+
+// ParseRelations parses a relationship field such as Depends.
+//
+// The result is a list of groups of alternatives separated by "|".
+// Continuation lines must be joined before calling this.
+func ParseRelations(s string) ([][]*Relation, error) {
+	var rels [][]*Relation
+	for _, group := range strings.Split(s, ",") {
+		if len(strings.TrimSpace(group)) == 0 {
+			// tolerate empty groups such as trailing commas.
+			continue
+		}
+		var alts []*Relation
+		for _, alt := range strings.Split(group, "|") {
+			r, err := ParseRelation(alt)
+			if err != nil {
+				return nil, err
+			}
+			alts = append(alts, r)
+		}
+		rels = append(rels, alts)
+	}
+	return rels, nil
+}
+
+// String returns r in the format of relationship fields.
+func (r *Relation) String() string {
+	s := r.Name
+	if len(r.Arch) > 0 {
+		s += ":" + r.Arch
+	}
+	if r.Version != nil {
+		s += " (" + r.Op + " " + r.Version.String() + ")"
+	}
+	if len(r.Architectures) > 0 {
+		s += " [" + strings.Join(r.Architectures, " ") + "]"
+	}
+	for _, p := range r.Profiles {
+		s += " <" + strings.Join(p, " ") + ">"
+	}
+	return s
+}
+
+// SatisfiedBy returns true if version v satisfies the version
+// constraint of r.  Relations without constraints are satisfied
+// by any version.
+func (r *Relation) SatisfiedBy(v *Version) bool {
+	if r.Version == nil {
+		return true
+	}
+
+	c := v.Compare(r.Version)
+	switch r.Op {
+	case "<<":
+		return c < 0
+	case "<=":
+		return c <= 0
+	case "=":
+		return c == 0
+	case ">=":
+		return c >= 0
+	case ">>":
+		return c > 0
+	}
+	return false
+}
@@ -0,0 +1,87 @@
+package apt
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseRelations(t *testing.T) {
+	t.Parallel()
+
+	rels, err := ParseRelations("libc6 (>= 2.14), python3:any | python3-minimal (<< 3.6~), " +
+		"debhelper (>= 9) [amd64 !i386] <!nocheck> <stage1 cross>, foo (< 1.0),")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rels) != 4 {
+		t.Fatal(`len(rels) != 4`, len(rels))
+	}
+
+	r := rels[0][0]
+	if r.Name != "libc6" || r.Op != ">=" || r.Version.String() != "2.14" {
+		t.Error(`unexpected relation`, r)
+	}
+
+	if len(rels[1]) != 2 {
+		t.Fatal(`len(rels[1]) != 2`)
+	}
+	if rels[1][0].Name != "python3" || rels[1][0].Arch != "any" || rels[1][0].Version != nil {
+		t.Error(`unexpected relation`, rels[1][0])
+	}
+	if rels[1][1].Name != "python3-minimal" || rels[1][1].Op != "<<" {
+		t.Error(`unexpected relation`, rels[1][1])
+	}
+
+	r = rels[2][0]
+	if !reflect.DeepEqual(r.Architectures, []string{"amd64", "!i386"}) {
+		t.Error(`!reflect.DeepEqual(r.Architectures)`, r.Architectures)
+	}
+	if !reflect.DeepEqual(r.Profiles, [][]string{{"!nocheck"}, {"stage1", "cross"}}) {
+		t.Error(`!reflect.DeepEqual(r.Profiles)`, r.Profiles)
+	}
+	if r.String() != "debhelper (>= 9) [amd64 !i386] <!nocheck> <stage1 cross>" {
+		t.Error(`unexpected string`, r.String())
+	}
+
+	// obsolete operators
+	if rels[3][0].Op != "<=" {
+		t.Error(`rels[3][0].Op != "<="`)
+	}
+
+	for _, s := range []string{"foo (>= )", "foo (~ 1.0)", "foo | ", "foo (>= 1.0", "Foo_bar", "foo:", "foo bar"} {
+		if _, err := ParseRelations(s); err == nil {
+			t.Error(`invalid relation must be rejected:`, s)
+		}
+	}
+}
+
+func TestRelationSatisfiedBy(t *testing.T) {
+	t.Parallel()
+
+	v, err := ParseVersion("1.0-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := []struct {
+		rel       string
+		satisfied bool
+	}{
+		{"foo", true},
+		{"foo (>= 1.0)", true},
+		{"foo (>> 1.0-1)", false},
+		{"foo (<< 1.0-2)", true},
+		{"foo (<= 1.0~)", false},
+		{"foo (= 1.0-1)", true},
+		{"foo (= 0:1.0-1)", true},
+	}
+	for _, tc := range testCases {
+		r, err := ParseRelation(tc.rel)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if r.SatisfiedBy(v) != tc.satisfied {
+			t.Error(`r.SatisfiedBy(v) != tc.satisfied`, tc.rel)
+		}
+	}
+}
@@ -0,0 +1,126 @@
+package apt
+
+// This file provides a typed model of Release and InRelease files.
+//
+// The specification is:
+// https://wiki.debian.org/DebianRepository/Format#A.22Release.22_files
+
+import (
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	// ErrReleaseExpired is returned by Release.CheckFresh if
+	// Valid-Until of the Release has passed.
+	ErrReleaseExpired = errors.New("Release has expired")
+
+	// ErrReleaseRollback is returned by Release.CheckNewer if
+	// Date of the Release goes backwards.
+	ErrReleaseRollback = errors.New("Release is older than the current one")
+
+	// formats of Date and Valid-Until fields.
+	releaseTimeLayouts = []string{
+		"Mon, _2 Jan 2006 15:04:05 MST",
+		"Mon, _2 Jan 2006 15:04:05 -0700",
+	}
+)
+
+// Release represents the contents of Release or InRelease file.
+type Release struct {
+	Origin   string
+	Label    string
+	Suite    string
+	Codename string
+
+	// Date is the creation time of the Release.
+	// Zero if Date field is missing.
+	Date time.Time
+
+	// ValidUntil is the expiration time of the Release.
+	// Zero if Valid-Until field is missing.
+	ValidUntil time.Time
+
+	Architectures []string
+	Components    []string
+	AcquireByHash bool
+
+	// Files is a list of indices listed in MD5Sum, SHA1, SHA256, and SHA512.
+	Files []*FileInfo
+}
+
+func parseReleaseTime(s string) (time.Time, error) {
+	// some repositories pad hours with spaces, e.g. "Wed, 12 Jul 2017  1:20:54 UTC"
+	s = strings.Join(strings.Fields(s), " ")
+
+	var err error
+	for _, layout := range releaseTimeLayouts {
+		var t time.Time
+		t, err = time.Parse(layout, s)
+		if err == nil {
+			return t.UTC(), nil
+		}
+	}
+	return time.Time{}, err
+}
+
+// ParseRelease constructs Release from a paragraph of Release file.
+//
+// p is the relative path of the Release file.
+func ParseRelease(p string, d Paragraph) (*Release, error) {
+	fil, err := FileInfoFromRelease(p, d)
+	if err != nil {
+		return nil, err
+	}
+
+	r := &Release{
+		Origin:        d.Value("Origin"),
+		Label:         d.Value("Label"),
+		Suite:         d.Value("Suite"),
+		Codename:      d.Value("Codename"),
+		Architectures: strings.Fields(d.Value("Architectures")),
+		Components:    strings.Fields(d.Value("Components")),
+		AcquireByHash: SupportByHash(d),
+		Files:         fil,
+	}
+
+	if date := d.Value("Date"); len(date) > 0 {
+		r.Date, err = parseReleaseTime(date)
+		if err != nil {
+			return nil, errors.Wrap(err, "Date in "+p)
+		}
+	}
+	if vu := d.Value("Valid-Until"); len(vu) > 0 {
+		r.ValidUntil, err = parseReleaseTime(vu)
+		if err != nil {
+			return nil, errors.Wrap(err, "Valid-Until in "+p)
+		}
+	}
+
+	return r, nil
+}
+
+// CheckFresh returns ErrReleaseExpired if Valid-Until of r
+// has passed at now.
+func (r *Release) CheckFresh(now time.Time) error {
+	if !r.ValidUntil.IsZero() && now.After(r.ValidUntil) {
+		return ErrReleaseExpired
+	}
+	return nil
+}
+
+// CheckNewer returns ErrReleaseRollback if Date of r is older
+// than that of old.  This protects clients from replay attacks.
+//
+// If either of r or old lacks Date, this returns nil.
+func (r *Release) CheckNewer(old *Release) error {
+	if r.Date.IsZero() || old.Date.IsZero() {
+		return nil
+	}
+	if r.Date.Before(old.Date) {
+		return ErrReleaseRollback
+	}
+	return nil
+}
@@ -0,0 +1,104 @@
+package apt
+
+import (
+	"os"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseRelease(t *testing.T) {
+	t.Parallel()
+
+	f, err := os.Open("testdata/hash/Release")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	_, d, err := ExtractFileInfo("ubuntu/dists/xenial-updates/Release", f)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := ParseRelease("ubuntu/dists/xenial-updates/Release", d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Origin != "Ubuntu" {
+		t.Error(`r.Origin != "Ubuntu"`)
+	}
+	if r.Suite != "xenial-updates" {
+		t.Error(`r.Suite != "xenial-updates"`)
+	}
+	if r.Codename != "xenial" {
+		t.Error(`r.Codename != "xenial"`)
+	}
+	if !r.Date.Equal(time.Date(2017, 7, 12, 1, 20, 54, 0, time.UTC)) {
+		t.Error(`r.Date != 2017-07-12 01:20:54`, r.Date)
+	}
+	if !r.ValidUntil.IsZero() {
+		t.Error(`!r.ValidUntil.IsZero()`)
+	}
+	if !reflect.DeepEqual(r.Components, []string{"main", "restricted", "universe", "multiverse"}) {
+		t.Error(`!reflect.DeepEqual(r.Components)`)
+	}
+	if len(r.Architectures) != 7 {
+		t.Error(`len(r.Architectures) != 7`)
+	}
+	if !r.AcquireByHash {
+		t.Error(`!r.AcquireByHash`)
+	}
+	if len(r.Files) == 0 {
+		t.Error(`len(r.Files) == 0`)
+	}
+
+	d = Paragraph{"Date": {"Sat, 5 Aug 2017 03:04:05 +0900"}}
+	r, err = ParseRelease("dists/stretch/Release", d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !r.Date.Equal(time.Date(2017, 8, 4, 18, 4, 5, 0, time.UTC)) {
+		t.Error(`r.Date != 2017-08-04 18:04:05`, r.Date)
+	}
+
+	d = Paragraph{"Date": {"yesterday"}}
+	_, err = ParseRelease("dists/stretch/Release", d)
+	if err == nil {
+		t.Error(`invalid Date must be an error`)
+	}
+}
+
+func TestReleaseFreshness(t *testing.T) {
+	t.Parallel()
+
+	d := Paragraph{
+		"Date":        {"Sat, 05 Aug 2017 00:00:00 UTC"},
+		"Valid-Until": {"Sat, 12 Aug 2017 00:00:00 UTC"},
+	}
+	r, err := ParseRelease("dists/stretch/Release", d)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := r.CheckFresh(time.Date(2017, 8, 10, 0, 0, 0, 0, time.UTC)); err != nil {
+		t.Error(err)
+	}
+	if err := r.CheckFresh(time.Date(2017, 8, 13, 0, 0, 0, 0, time.UTC)); err != ErrReleaseExpired {
+		t.Error(`err != ErrReleaseExpired`)
+	}
+
+	older := &Release{Date: time.Date(2017, 8, 1, 0, 0, 0, 0, time.UTC)}
+	if err := r.CheckNewer(older); err != nil {
+		t.Error(err)
+	}
+	if err := r.CheckNewer(r); err != nil {
+		t.Error(err)
+	}
+	if err := older.CheckNewer(r); err != ErrReleaseRollback {
+		t.Error(`err != ErrReleaseRollback`)
+	}
+	if err := r.CheckNewer(&Release{}); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,199 @@
+package apt
+
+// This file implements OpenPGP signature verification for
+// Release, Release.gpg, and InRelease files.
+//
+// Specifications are:
+// https://wiki.debian.org/SecureApt
+// https://wiki.debian.org/DebianRepository/Format#A.22Release.22_files
+//
+// github.com/ProtonMail/go-crypto is used instead of the frozen
+// golang.org/x/crypto/openpgp because the latter cannot read Ed25519
+// keys found in recent Debian keyrings.
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/armor"
+	"github.com/ProtonMail/go-crypto/openpgp/clearsign"
+	"github.com/pkg/errors"
+)
+
+var (
+	// ErrNotSigned is returned by Keyring.VerifyInRelease if
+	// the data is not a clearsigned message.
+	ErrNotSigned = errors.New("not a clearsigned message")
+
+	armorHeader     = []byte("-----BEGIN PGP")
+	clearsignHeader = []byte("-----BEGIN PGP SIGNED MESSAGE-----")
+)
+
+// Keyring is a set of OpenPGP public keys trusted to sign Release files.
+type Keyring struct {
+	el openpgp.EntityList
+}
+
+// ReadKeyring reads OpenPGP public keys from r.
+//
+// Both ASCII armored and binary keyrings are accepted.
+func ReadKeyring(r io.Reader) (*Keyring, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var el openpgp.EntityList
+	if bytes.HasPrefix(bytes.TrimSpace(data), armorHeader) {
+		el, err = openpgp.ReadArmoredKeyRing(bytes.NewReader(data))
+	} else {
+		el, err = openpgp.ReadKeyRing(bytes.NewReader(data))
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "ReadKeyring")
+	}
+	if len(el) == 0 {
+		return nil, errors.New("ReadKeyring: no keys")
+	}
+	return &Keyring{el: el}, nil
+}
+
+// LoadKeyring reads OpenPGP public keys from files such as
+// "/usr/share/keyrings/debian-archive-keyring.gpg".
+//
+// Keys in all files are merged into the returned Keyring.
+func LoadKeyring(files ...string) (*Keyring, error) {
+	if len(files) == 0 {
+		return nil, errors.New("LoadKeyring: no files")
+	}
+
+	kr := new(Keyring)
+	for _, fn := range files {
+		f, err := os.Open(fn)
+		if err != nil {
+			return nil, err
+		}
+		kr2, err := ReadKeyring(f)
+		f.Close()
+		if err != nil {
+			return nil, errors.Wrap(err, fn)
+		}
+		kr.el = append(kr.el, kr2.el...)
+	}
+	return kr, nil
+}
+
+// Len returns the number of keys in the keyring.
+func (k *Keyring) Len() int {
+	return len(k.el)
+}
+
+// check verifies a detached signature and returns the fingerprint
+// of the signer in upper-case hex.
+func (k *Keyring) check(signed []byte, signature io.Reader) (string, error) {
+	signer, err := openpgp.CheckDetachedSignature(k.el, bytes.NewReader(signed), signature, nil)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%X", signer.PrimaryKey.Fingerprint), nil
+}
+
+// parseSigned parses the signed body of a Release file.
+func parseSigned(data []byte) (Paragraph, error) {
+	d, err := NewParser(bytes.NewReader(data)).Read()
+	if err != nil {
+		return nil, errors.Wrap(err, "NewParser(r).Read()")
+	}
+	return d, nil
+}
+
+// decodeClearsigned decodes a clearsigned message.
+//
+// As other parsers would read data outside of the signed body,
+// data must consist of a clearsigned message only, optionally
+// surrounded by white spaces.
+func decodeClearsigned(data []byte) (*clearsign.Block, error) {
+	if !bytes.HasPrefix(bytes.TrimSpace(data), clearsignHeader) {
+		return nil, ErrNotSigned
+	}
+	b, rest := clearsign.Decode(data)
+	if b == nil {
+		return nil, ErrNotSigned
+	}
+	if len(bytes.TrimSpace(rest)) > 0 {
+		return nil, errors.New("data after the signature")
+	}
+	return b, nil
+}
+
+// SignedText returns the signed text of clearsigned data such as
+// InRelease without verifying the signature.
+//
+// The text is the same one that Keyring.VerifyInRelease verifies,
+// so it can be used to read the verified data again.
+func SignedText(data []byte) ([]byte, error) {
+	b, err := decodeClearsigned(data)
+	if err != nil {
+		return nil, err
+	}
+	return b.Plaintext, nil
+}
+
+// VerifyInRelease verifies the clearsigned InRelease data.
+//
+// If the signature is valid, this returns the fingerprint of the
+// signer key and the paragraph parsed from the signed body.
+//
+// data must consist of a clearsigned message only, optionally
+// surrounded by white spaces.
+func (k *Keyring) VerifyInRelease(data []byte) (string, Paragraph, error) {
+	b, err := decodeClearsigned(data)
+	if err == ErrNotSigned {
+		return "", nil, err
+	}
+	if err != nil {
+		return "", nil, errors.Wrap(err, "VerifyInRelease")
+	}
+
+	fpr, err := k.check(b.Bytes, b.ArmoredSignature.Body)
+	if err != nil {
+		return "", nil, errors.Wrap(err, "VerifyInRelease")
+	}
+
+	d, err := parseSigned(b.Plaintext)
+	if err != nil {
+		return "", nil, err
+	}
+	return fpr, d, nil
+}
+
+// VerifyRelease verifies Release data with a detached signature
+// read from Release.gpg.  The signature may be ASCII armored or binary.
+//
+// If the signature is valid, this returns the fingerprint of the
+// signer key and the paragraph parsed from release.
+func (k *Keyring) VerifyRelease(release, signature []byte) (string, Paragraph, error) {
+	var sig io.Reader = bytes.NewReader(signature)
+	if bytes.HasPrefix(bytes.TrimSpace(signature), armorHeader) {
+		block, err := armor.Decode(sig)
+		if err != nil {
+			return "", nil, errors.Wrap(err, "VerifyRelease")
+		}
+		sig = block.Body
+	}
+
+	fpr, err := k.check(release, sig)
+	if err != nil {
+		return "", nil, errors.Wrap(err, "VerifyRelease")
+	}
+
+	d, err := parseSigned(release)
+	if err != nil {
+		return "", nil, err
+	}
+	return fpr, d, nil
+}
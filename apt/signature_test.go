@@ -0,0 +1,152 @@
+package apt
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+const testSigner = "0FA31331A5295660AE41C68F5AA6F87C07FCEF9B"
+
+func readTestFile(t *testing.T, p string) []byte {
+	data, err := ioutil.ReadFile(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func testKeyringRead(t *testing.T) {
+	t.Parallel()
+
+	for _, p := range []string{"testdata/gpg/keyring.asc", "testdata/gpg/keyring.gpg"} {
+		f, err := os.Open(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		kr, err := ReadKeyring(f)
+		f.Close()
+		if err != nil {
+			t.Fatal(p, err)
+		}
+		if kr.Len() != 1 {
+			t.Error(p, `kr.Len() != 1`)
+		}
+	}
+
+	kr, err := LoadKeyring("testdata/gpg/keyring.asc", "testdata/gpg/other.asc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if kr.Len() != 2 {
+		t.Error(`kr.Len() != 2`)
+	}
+
+	_, err = ReadKeyring(bytes.NewReader(nil))
+	if err == nil {
+		t.Error(`ReadKeyring must fail for empty data`)
+	}
+}
+
+func testKeyringInRelease(t *testing.T) {
+	t.Parallel()
+
+	kr, err := LoadKeyring("testdata/gpg/keyring.gpg")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data := readTestFile(t, "testdata/gpg/InRelease")
+	fpr, d, err := kr.VerifyInRelease(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fpr != testSigner {
+		t.Error(`fpr != testSigner`, fpr)
+	}
+	if codename, ok := d["Codename"]; !ok || codename[0] != "testing" {
+		t.Error(`codename != "testing"`)
+	}
+
+	tampered := bytes.Replace(data, []byte("Codename: testing"), []byte("Codename: hacked!"), 1)
+	_, _, err = kr.VerifyInRelease(tampered)
+	if err == nil {
+		t.Error(`tampered InRelease must not be verified`)
+	}
+
+	prepended := append([]byte("Date: Fri, 01 Jan 2100 00:00:00 UTC\nSHA256:\n 0000 1 main/binary-amd64/Packages\n\n"), data...)
+	_, _, err = kr.VerifyInRelease(prepended)
+	if err != ErrNotSigned {
+		t.Error(`InRelease with a prepended paragraph must not be verified`, err)
+	}
+
+	appended := append(append([]byte{}, data...), "\nDate: Fri, 01 Jan 2100 00:00:00 UTC\n"...)
+	_, _, err = kr.VerifyInRelease(appended)
+	if err == nil {
+		t.Error(`InRelease with appended text must not be verified`)
+	}
+
+	_, _, err = kr.VerifyInRelease(append([]byte("\n"), data...))
+	if err != nil {
+		t.Error(`leading white spaces must be accepted`, err)
+	}
+
+	text, err := SignedText(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(text, []byte("Origin: ")) || bytes.Contains(text, []byte("-----")) {
+		t.Error(`unexpected signed text`, string(text))
+	}
+	if _, err := SignedText(prepended); err != ErrNotSigned {
+		t.Error(`SignedText must reject a prepended paragraph`, err)
+	}
+	if _, err := SignedText(appended); err == nil {
+		t.Error(`SignedText must reject appended text`)
+	}
+
+	_, _, err = kr.VerifyInRelease(readTestFile(t, "testdata/gpg/InRelease.other"))
+	if err == nil {
+		t.Error(`InRelease signed by unknown key must not be verified`)
+	}
+
+	_, _, err = kr.VerifyInRelease(readTestFile(t, "testdata/gpg/Release"))
+	if err != ErrNotSigned {
+		t.Error(`err != ErrNotSigned`)
+	}
+}
+
+func testKeyringRelease(t *testing.T) {
+	t.Parallel()
+
+	kr, err := LoadKeyring("testdata/gpg/keyring.asc")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	release := readTestFile(t, "testdata/gpg/Release")
+	sig := readTestFile(t, "testdata/gpg/Release.gpg")
+	fpr, d, err := kr.VerifyRelease(release, sig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fpr != testSigner {
+		t.Error(`fpr != testSigner`, fpr)
+	}
+	if len(d["SHA256"]) != 9 {
+		t.Error(`len(d["SHA256"]) != 9`)
+	}
+
+	tampered := bytes.Replace(release, []byte("Codename: testing"), []byte("Codename: hacked!"), 1)
+	_, _, err = kr.VerifyRelease(tampered, sig)
+	if err == nil {
+		t.Error(`tampered Release must not be verified`)
+	}
+}
+
+func TestKeyring(t *testing.T) {
+	t.Run("Read", testKeyringRead)
+	t.Run("InRelease", testKeyringInRelease)
+	t.Run("Release", testKeyringRelease)
+}
@@ -0,0 +1,112 @@
+package apt
+
+// This file implements OpenPGP signing of Release files for
+// repositories whose indices are rewritten locally.
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"time"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/clearsign"
+	"github.com/pkg/errors"
+)
+
+// Signer signs Release files with an OpenPGP secret key.
+type Signer struct {
+	e *openpgp.Entity
+}
+
+// ReadSigner reads an OpenPGP secret key from r.
+//
+// Both ASCII armored and binary keys are accepted.
+// The key must not be protected by a passphrase.
+func ReadSigner(r io.Reader) (*Signer, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var el openpgp.EntityList
+	if bytes.HasPrefix(bytes.TrimSpace(data), armorHeader) {
+		el, err = openpgp.ReadArmoredKeyRing(bytes.NewReader(data))
+	} else {
+		el, err = openpgp.ReadKeyRing(bytes.NewReader(data))
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "ReadSigner")
+	}
+
+	for _, e := range el {
+		key, ok := e.SigningKey(time.Now())
+		if !ok || key.PrivateKey == nil {
+			continue
+		}
+		if key.PrivateKey.Encrypted {
+			return nil, errors.New("ReadSigner: secret key is protected by a passphrase")
+		}
+		return &Signer{e: e}, nil
+	}
+	return nil, errors.New("ReadSigner: no secret keys for signing")
+}
+
+// LoadSigner reads an OpenPGP secret key from file.
+func LoadSigner(file string) (*Signer, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	s, err := ReadSigner(f)
+	if err != nil {
+		return nil, errors.Wrap(err, file)
+	}
+	return s, nil
+}
+
+// Fingerprint returns the fingerprint of the key in upper-case hex.
+func (s *Signer) Fingerprint() string {
+	return fmt.Sprintf("%X", s.e.PrimaryKey.Fingerprint)
+}
+
+// Keyring returns a Keyring that trusts the key of s.
+func (s *Signer) Keyring() *Keyring {
+	return &Keyring{el: openpgp.EntityList{s.e}}
+}
+
+// SignInRelease returns InRelease data, that is release clearsigned.
+func (s *Signer) SignInRelease(release []byte) ([]byte, error) {
+	key, ok := s.e.SigningKey(time.Now())
+	if !ok {
+		return nil, errors.New("SignInRelease: no valid signing key")
+	}
+
+	var buf bytes.Buffer
+	w, err := clearsign.Encode(&buf, key.PrivateKey, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "SignInRelease")
+	}
+	if _, err := w.Write(release); err != nil {
+		return nil, errors.Wrap(err, "SignInRelease")
+	}
+	if err := w.Close(); err != nil {
+		return nil, errors.Wrap(err, "SignInRelease")
+	}
+	return buf.Bytes(), nil
+}
+
+// SignRelease returns Release.gpg data, that is an ASCII armored
+// detached signature of release.
+func (s *Signer) SignRelease(release []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	err := openpgp.ArmoredDetachSign(&buf, s.e, bytes.NewReader(release), nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "SignRelease")
+	}
+	return buf.Bytes(), nil
+}
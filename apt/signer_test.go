@@ -0,0 +1,63 @@
+package apt
+
+import (
+	"bytes"
+	"io/ioutil"
+	"testing"
+)
+
+func TestSigner(t *testing.T) {
+	t.Parallel()
+
+	s, err := LoadSigner("testdata/gpg/signing.asc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadSigner("testdata/gpg/keyring.asc"); err == nil {
+		t.Error(`public keys must not be accepted`)
+	}
+
+	release, err := ioutil.ReadFile("testdata/gpg/Release")
+	if err != nil {
+		t.Fatal(err)
+	}
+	kr := s.Keyring()
+
+	inRelease, err := s.SignInRelease(release)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fpr, d, err := kr.VerifyInRelease(inRelease)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fpr != s.Fingerprint() {
+		t.Error(`fpr != s.Fingerprint()`)
+	}
+	if d["Codename"][0] != "testing" {
+		t.Error(`d["Codename"][0] != "testing"`)
+	}
+	text, err := SignedText(inRelease)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(text, release) {
+		t.Error(`signed text must be release`)
+	}
+
+	sig, err := s.SignRelease(release)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := kr.VerifyRelease(release, sig); err != nil {
+		t.Error(err)
+	}
+
+	other, err := LoadKeyring("testdata/gpg/keyring.asc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := other.VerifyRelease(release, sig); err == nil {
+		t.Error(`signature must not be verified by other keys`)
+	}
+}
@@ -0,0 +1,85 @@
+package apt
+
+// This file implements a parser for checksum lists such as SHA256SUMS
+// that accompany debian-installer images.  They are outputs of
+// md5sum(1) or sha256sum(1) and are not referenced from Packages.
+
+import (
+	"bufio"
+	"encoding/hex"
+	"io"
+	"path"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+var checksumSizes = map[ChecksumType]int{
+	ChecksumMD5:    16,
+	ChecksumSHA1:   20,
+	ChecksumSHA256: 32,
+	ChecksumSHA512: 64,
+}
+
+// ParseChecksumList parses a checksum list of type ct such as
+// SHA256SUMS and returns a list of *FileInfo listed in it.
+//
+// Paths in the list are relative to dir, and must not point outside
+// of it.  As checksum lists do not have file sizes, returned FileInfo
+// do not have sizes; see FileInfo.HasSize.
+func ParseChecksumList(dir string, ct ChecksumType, r io.Reader) ([]*FileInfo, error) {
+	csize, ok := checksumSizes[ct]
+	if !ok {
+		return nil, errors.New("unsupported checksum type: " + ct.String())
+	}
+
+	var l []*FileInfo
+	s := bufio.NewScanner(r)
+	lineno := 0
+	for s.Scan() {
+		lineno++
+		line := strings.TrimRight(s.Text(), " \t\r")
+		if len(line) == 0 {
+			continue
+		}
+
+		// "CHECKSUM  PATH" or "CHECKSUM *PATH" for binary mode.
+		i := strings.IndexByte(line, ' ')
+		if i < 0 || i+2 > len(line) {
+			return nil, errors.Errorf("invalid line %d: %s", lineno, line)
+		}
+		csum, err := hex.DecodeString(line[:i])
+		if err != nil || len(csum) != csize {
+			return nil, errors.Errorf("invalid checksum at line %d: %s", lineno, line)
+		}
+		p := line[i+1:]
+		if p[0] == ' ' || p[0] == '*' {
+			p = p[1:]
+		}
+
+		p = path.Clean(p)
+		if path.IsAbs(p) || p == ".." || strings.HasPrefix(p, "../") {
+			return nil, errors.Errorf("invalid path at line %d: %s", lineno, line)
+		}
+
+		fi := &FileInfo{
+			path:   path.Join(dir, p),
+			noSize: true,
+		}
+		switch ct {
+		case ChecksumMD5:
+			fi.md5sum = csum
+		case ChecksumSHA1:
+			fi.sha1sum = csum
+		case ChecksumSHA256:
+			fi.sha256sum = csum
+		case ChecksumSHA512:
+			fi.sha512sum = csum
+		}
+		l = append(l, fi)
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
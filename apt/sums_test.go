@@ -0,0 +1,60 @@
+package apt
+
+import (
+	"encoding/hex"
+	"strings"
+	"testing"
+)
+
+func TestParseChecksumList(t *testing.T) {
+	t.Parallel()
+
+	data := []byte("netboot")
+	fi := MakeFileInfo("main/installer-amd64/current/images/netboot/netboot.tar.gz", data)
+	sha256 := fi.Checksum(ChecksumSHA256)
+
+	list := `
+` + hex.EncodeToString(sha256) + `  ./netboot/netboot.tar.gz
+` + hex.EncodeToString(sha256) + ` *cdrom/initrd.gz
+`
+	fil, err := ParseChecksumList("main/installer-amd64/current/images", ChecksumSHA256, strings.NewReader(list))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(fil) != 2 {
+		t.Fatal(`len(fil) != 2`, len(fil))
+	}
+	if fil[0].Path() != "main/installer-amd64/current/images/netboot/netboot.tar.gz" {
+		t.Error(`unexpected path`, fil[0].Path())
+	}
+	if fil[1].Path() != "main/installer-amd64/current/images/cdrom/initrd.gz" {
+		t.Error(`unexpected path`, fil[1].Path())
+	}
+	if fil[0].HasSize() {
+		t.Error(`fil[0].HasSize()`)
+	}
+	if fil[0].StrongestChecksum() != ChecksumSHA256 {
+		t.Error(`fil[0].StrongestChecksum() != ChecksumSHA256`)
+	}
+	if !fil[0].Same(fi) {
+		t.Error(`!fil[0].Same(fi)`)
+	}
+	if fil[0].Same(MakeFileInfo(fi.Path(), []byte("netboot2"))) {
+		t.Error(`fil[0] must not match different data`)
+	}
+
+	for _, bad := range []string{
+		"0123  foo\n",
+		hex.EncodeToString(sha256) + "\n",
+		hex.EncodeToString(sha256) + "  ../foo\n",
+		hex.EncodeToString(sha256) + "  /etc/passwd\n",
+	} {
+		_, err := ParseChecksumList("images", ChecksumSHA256, strings.NewReader(bad))
+		if err == nil {
+			t.Error(`invalid list must be rejected:`, bad)
+		}
+	}
+	if _, err := ParseChecksumList("images", ChecksumNone, strings.NewReader(list)); err == nil {
+		t.Error(`ChecksumNone must be rejected`)
+	}
+}
@@ -0,0 +1,212 @@
+package apt
+
+// This file implements Debian package versions.
+//
+// The specification is:
+// https://www.debian.org/doc/debian-policy/ch-controlfields.html#version
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Version represents a Debian package version.
+type Version struct {
+	// Epoch is zero if omitted.
+	Epoch int
+
+	Upstream string
+
+	// Revision is the debian revision, or empty if omitted.
+	Revision string
+}
+
+// splitVersion splits a version into epoch, upstream version,
+// and debian revision.
+func splitVersion(v string) (epoch int, upstream, revision string) {
+	v = strings.TrimSpace(v)
+	if i := strings.IndexByte(v, ':'); i >= 0 {
+		if e, err := strconv.Atoi(v[:i]); err == nil {
+			epoch = e
+			v = v[i+1:]
+		}
+	}
+	if i := strings.LastIndexByte(v, '-'); i >= 0 {
+		return epoch, v[:i], v[i+1:]
+	}
+	return epoch, v, ""
+}
+
+func validVersionPart(s, extra string) bool {
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if isDigit(c) || isLetter(c) || strings.IndexByte(".+~"+extra, c) >= 0 {
+			continue
+		}
+		return false
+	}
+	return true
+}
+
+// ParseVersion parses a version string such as "1:2.30-1ubuntu1".
+//
+// Unlike CompareVersions, this validates the version string.
+func ParseVersion(s string) (*Version, error) {
+	s = strings.TrimSpace(s)
+	if len(s) == 0 {
+		return nil, errors.New("empty version")
+	}
+	if strings.ContainsAny(s, " \t") {
+		return nil, errors.New("version has embedded spaces: " + s)
+	}
+
+	v := new(Version)
+	rest := s
+	if i := strings.IndexByte(s, ':'); i >= 0 {
+		epoch, err := strconv.Atoi(s[:i])
+		if err != nil || epoch < 0 {
+			return nil, errors.New("invalid epoch in version: " + s)
+		}
+		v.Epoch = epoch
+		rest = s[i+1:]
+	}
+
+	v.Upstream = rest
+	if i := strings.LastIndexByte(rest, '-'); i >= 0 {
+		v.Upstream, v.Revision = rest[:i], rest[i+1:]
+		if len(v.Revision) == 0 {
+			return nil, errors.New("empty revision in version: " + s)
+		}
+	}
+	if len(v.Upstream) == 0 {
+		return nil, errors.New("empty upstream version: " + s)
+	}
+
+	if !validVersionPart(v.Upstream, "-:") || !validVersionPart(v.Revision, "") {
+		return nil, errors.New("invalid character in version: " + s)
+	}
+	return v, nil
+}
+
+// String returns the version string.
+func (v *Version) String() string {
+	s := v.Upstream
+	if v.Epoch > 0 {
+		s = strconv.Itoa(v.Epoch) + ":" + s
+	}
+	if len(v.Revision) > 0 {
+		s += "-" + v.Revision
+	}
+	return s
+}
+
+// Compare compares v with o in the same way as dpkg.
+//
+// The result is negative if v is older than o, zero if they are
+// equal, and positive if v is newer than o.
+func (v *Version) Compare(o *Version) int {
+	if v.Epoch != o.Epoch {
+		if v.Epoch < o.Epoch {
+			return -1
+		}
+		return 1
+	}
+	if c := compareVersionPart(v.Upstream, o.Upstream); c != 0 {
+		return c
+	}
+	return compareVersionPart(v.Revision, o.Revision)
+}
+
+func isDigit(c byte) bool {
+	return '0' <= c && c <= '9'
+}
+
+func isLetter(c byte) bool {
+	return ('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z')
+}
+
+// versionOrder returns the sort weight of a non-digit character.
+// Tildes sort before anything, even the end of a part.
+func versionOrder(c byte) int {
+	switch {
+	case c == 0 || isDigit(c):
+		return 0
+	case isLetter(c):
+		return int(c)
+	case c == '~':
+		return -1
+	}
+	return int(c) + 256
+}
+
+// compareVersionPart compares upstream versions or debian revisions
+// in the same way as verrevcmp of dpkg.
+func compareVersionPart(a, b string) int {
+	at := func(s string, i int) byte {
+		if i < len(s) {
+			return s[i]
+		}
+		return 0
+	}
+
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		for (i < len(a) && !isDigit(a[i])) || (j < len(b) && !isDigit(b[j])) {
+			ac := versionOrder(at(a, i))
+			bc := versionOrder(at(b, j))
+			if ac != bc {
+				return ac - bc
+			}
+			i++
+			j++
+		}
+
+		for i < len(a) && a[i] == '0' {
+			i++
+		}
+		for j < len(b) && b[j] == '0' {
+			j++
+		}
+
+		firstDiff := 0
+		for i < len(a) && isDigit(a[i]) && j < len(b) && isDigit(b[j]) {
+			if firstDiff == 0 {
+				firstDiff = int(a[i]) - int(b[j])
+			}
+			i++
+			j++
+		}
+		if i < len(a) && isDigit(a[i]) {
+			return 1
+		}
+		if j < len(b) && isDigit(b[j]) {
+			return -1
+		}
+		if firstDiff != 0 {
+			return firstDiff
+		}
+	}
+	return 0
+}
+
+// CompareVersions compares Debian package versions a and b in the
+// same way as dpkg --compare-versions.
+//
+// The result is negative if a is older than b, zero if they are
+// equal, and positive if a is newer than b.
+func CompareVersions(a, b string) int {
+	ea, ua, ra := splitVersion(a)
+	eb, ub, rb := splitVersion(b)
+	if ea != eb {
+		if ea < eb {
+			return -1
+		}
+		return 1
+	}
+	if c := compareVersionPart(ua, ub); c != 0 {
+		return c
+	}
+	return compareVersionPart(ra, rb)
+}
@@ -0,0 +1,91 @@
+package apt
+
+import "testing"
+
+func TestCompareVersions(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		a, b   string
+		result int
+	}{
+		{"1.0", "1.0", 0},
+		{"1.0", "1.1", -1},
+		{"1.10", "1.9", 1},
+		{"1.0-1", "1.0-2", -1},
+		{"1.0-10", "1.0-9", 1},
+		{"1:0.1", "2.0", 1},
+		{"0:2.0", "2.0", 0},
+		{"1.0~rc1", "1.0", -1},
+		{"1.0~rc1", "1.0~rc2", -1},
+		{"1.0~~", "1.0~", -1},
+		{"1.0", "1.0+b1", -1},
+		{"1.0a", "1.0+", -1},
+		{"1.0", "1.0a", -1},
+		{"1.001", "1.1", 0},
+		{"2.30-1ubuntu1", "2.30-1", 1},
+		{"1.2.3-4-5", "1.2.3-4", 1},
+	}
+	sign := func(i int) int {
+		switch {
+		case i < 0:
+			return -1
+		case i > 0:
+			return 1
+		}
+		return 0
+	}
+	for _, tc := range testCases {
+		if r := sign(CompareVersions(tc.a, tc.b)); r != tc.result {
+			t.Error(`CompareVersions(tc.a, tc.b) != tc.result`, tc.a, tc.b, r)
+		}
+		if r := sign(CompareVersions(tc.b, tc.a)); r != -tc.result {
+			t.Error(`CompareVersions(tc.b, tc.a) != -tc.result`, tc.b, tc.a, r)
+		}
+	}
+}
+
+func TestParseVersion(t *testing.T) {
+	t.Parallel()
+
+	v, err := ParseVersion("1:2.30-1ubuntu1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v.Epoch != 1 || v.Upstream != "2.30" || v.Revision != "1ubuntu1" {
+		t.Error(`unexpected version`, v)
+	}
+	if v.String() != "1:2.30-1ubuntu1" {
+		t.Error(`v.String() != "1:2.30-1ubuntu1"`, v.String())
+	}
+
+	v, err = ParseVersion("2:1.0-rc1-3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v.Upstream != "1.0-rc1" || v.Revision != "3" {
+		t.Error(`v.Upstream != "1.0-rc1" || v.Revision != "3"`, v)
+	}
+
+	v, err = ParseVersion("1.0~beta")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v.Epoch != 0 || v.Revision != "" || v.String() != "1.0~beta" {
+		t.Error(`unexpected version`, v)
+	}
+
+	v2, err := ParseVersion("0:1.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v.Compare(v2) >= 0 {
+		t.Error(`v.Compare(v2) >= 0`)
+	}
+
+	for _, s := range []string{"", "1.0 1", "a:1.0", "-1:1.0", "1.0-", "1:", "-1", "1.0_1", "1.0-1:2"} {
+		if _, err := ParseVersion(s); err == nil {
+			t.Error(`invalid version must be rejected:`, s)
+		}
+	}
+}
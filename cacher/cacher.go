@@ -6,6 +6,7 @@ package cacher
 import (
 	"bytes"
 	"context"
+	"io"
 	"io/ioutil"
 	"net/http"
 	"net/url"
@@ -45,12 +46,15 @@ type Cacher struct {
 	cachePeriod   time.Duration
 	client        *http.Client
 	maxConns      int
+	keyrings      map[string]*apt.Keyring
+	minChecksum   apt.ChecksumType
 
 	fiLock sync.RWMutex
 	info   map[string]*apt.FileInfo
 
 	dlLock     sync.RWMutex
 	dlChannels map[string]chan struct{}
+	spools     map[string]*spool
 	results    map[string]int
 
 	hostLock sync.Mutex
@@ -109,6 +113,23 @@ func NewCacher(config *Config) (*Cacher, error) {
 		}
 	}
 
+	keyrings := make(map[string]*apt.Keyring)
+	for prefix, fn := range config.Keyring {
+		if _, ok := um[prefix]; !ok {
+			return nil, errors.New("keyring for unknown prefix: " + prefix)
+		}
+		kr, err := apt.LoadKeyring(fn)
+		if err != nil {
+			return nil, errors.Wrap(err, prefix)
+		}
+		keyrings[prefix] = kr
+	}
+
+	minChecksum, err := apt.ParseChecksumType(config.MinChecksum)
+	if err != nil {
+		return nil, err
+	}
+
 	c := &Cacher{
 		meta:          meta,
 		items:         cache,
@@ -117,22 +138,51 @@ func NewCacher(config *Config) (*Cacher, error) {
 		cachePeriod:   cachePeriod,
 		client:        &http.Client{},
 		maxConns:      config.MaxConns,
+		keyrings:      keyrings,
+		minChecksum:   minChecksum,
 		info:          make(map[string]*apt.FileInfo),
 		dlChannels:    make(map[string]chan struct{}),
+		spools:        make(map[string]*spool),
 		results:       make(map[string]int),
 		hostSem:       make(map[string]chan struct{}),
 	}
 
-	metas := meta.ListAll()
-	for _, fi := range metas {
-		f, err := meta.Lookup(fi)
-		if err != nil {
-			return nil, errors.Wrap(err, "meta.Lookup")
-		}
+	metaMap := make(map[string]*apt.FileInfo)
+	for _, fi := range meta.ListAll() {
+		metaMap[fi.Path()] = fi
+	}
+
+	var metas []*apt.FileInfo
+	for _, fi := range meta.ListAll() {
 		t := strings.SplitN(fi.Path(), "/", 2)
 		if len(t) != 2 {
 			panic("there should always be a prefix!")
 		}
+
+		if c.keyring(fi.Path()) != nil && isRelease(fi.Path()) {
+			// checksums must be read only from verified data.
+			fil, err := c.loadRelease(fi.Path(), metaMap)
+			if err != nil {
+				log.Warn("removed unverified Release", map[string]interface{}{
+					"path":  fi.Path(),
+					"error": err.Error(),
+				})
+				if err := meta.Delete(fi.Path()); err != nil {
+					return nil, errors.Wrap(err, "meta.Delete")
+				}
+				continue
+			}
+			for _, fi2 := range fil {
+				c.info[fi2.Path()] = fi2
+			}
+			metas = append(metas, fi)
+			continue
+		}
+
+		f, err := meta.Lookup(fi)
+		if err != nil {
+			return nil, errors.Wrap(err, "meta.Lookup")
+		}
 		fil, _, err := apt.ExtractFileInfo(t[1], f)
 		f.Close()
 		if err != nil {
@@ -142,6 +192,7 @@ func NewCacher(config *Config) (*Cacher, error) {
 		for _, fi2 := range fil {
 			c.info[fi2.Path()] = fi2
 		}
+		metas = append(metas, fi)
 	}
 
 	// add meta files w/o checksums (Release, Release.gpg, and InRelease).
@@ -188,8 +239,11 @@ func (c *Cacher) releaseSemaphore(host string) {
 func (c *Cacher) maintMeta(p string) {
 	switch path.Base(p) {
 	case "Release":
+		// Release.gpg is downloaded together with Release
+		// if the signature need to be verified.
+		withGPG := c.keyring(p) == nil
 		cmd.Go(func(ctx context.Context) error {
-			c.maintRelease(ctx, p, true)
+			c.maintRelease(ctx, p, withGPG)
 			return nil
 		})
 	case "InRelease":
@@ -237,9 +291,19 @@ func (c *Cacher) maintRelease(ctx context.Context, p string, withGPG bool) {
 // Users of this method should retry if the item is not cached
 // or invalidated.
 func (c *Cacher) Download(p string, valid *apt.FileInfo) <-chan struct{} {
+	ch, _ := c.startDownload(p, valid)
+	return ch
+}
+
+// startDownload starts downloading an item unless it is being
+// downloaded already.
+//
+// In addition to the channel returned by Download, this returns
+// a spool to read non-meta items during download.
+func (c *Cacher) startDownload(p string, valid *apt.FileInfo) (chan struct{}, *spool) {
 	u := c.um.URL(p)
 	if u == nil {
-		return nil
+		return nil, nil
 	}
 
 	c.dlLock.Lock()
@@ -247,29 +311,45 @@ func (c *Cacher) Download(p string, valid *apt.FileInfo) <-chan struct{} {
 
 	ch, ok := c.dlChannels[p]
 	if ok {
-		return ch
+		return ch, c.spools[p]
 	}
 
 	ch = make(chan struct{})
 	c.dlChannels[p] = ch
+
+	// meta data are not streamed as they need to be parsed
+	// before they are served.
+	var sp *spool
+	if !apt.IsMeta(p) {
+		sp = newSpool()
+		c.spools[p] = sp
+	}
+
 	cmd.Go(func(ctx context.Context) error {
-		c.download(ctx, p, u, valid)
+		c.download(ctx, p, u, valid, sp)
 		return nil
 	})
-	return ch
+	return ch, sp
 }
 
 // download is a goroutine to download an item.
-func (c *Cacher) download(ctx context.Context, p string, u *url.URL, valid *apt.FileInfo) {
+//
+// If sp is not nil, the item is written to sp.
+func (c *Cacher) download(ctx context.Context, p string, u *url.URL, valid *apt.FileInfo, sp *spool) {
 	c.acquireSemaphore(u.Host)
 
 	statusCode := http.StatusInternalServerError
 
 	defer func() {
 		c.releaseSemaphore(u.Host)
+		if sp != nil {
+			// for failures before sp is started.
+			sp.finish(errSpoolAborted)
+		}
 		c.dlLock.Lock()
 		ch := c.dlChannels[p]
 		delete(c.dlChannels, p)
+		delete(c.spools, p)
 		c.results[p] = statusCode
 		c.dlLock.Unlock()
 		close(ch)
@@ -307,6 +387,10 @@ func (c *Cacher) download(ctx context.Context, p string, u *url.URL, valid *apt.
 		})
 		return
 	}
+	if sp != nil {
+		statusCode = c.spoolItem(p, resp, valid, sp)
+		return
+	}
 	body, err := ioutil.ReadAll(resp.Body)
 	resp.Body.Close()
 
@@ -338,54 +422,193 @@ func (c *Cacher) download(ctx context.Context, p string, u *url.URL, valid *apt.
 		panic("path must has a prefix: " + p)
 	}
 
-	if apt.IsMeta(p) {
+	// the counterpart of Release or Release.gpg; see verifyRelease.
+	var pair *signedFile
+
+	kr := c.keyring(p)
+	switch {
+	case !apt.IsMeta(p):
+	case kr != nil && isSignedRelease(p):
 		storage = c.meta
-		fil, _, err = apt.ExtractFileInfo(t[1], bytes.NewReader(body))
+		var rel *apt.Release
+		rel, pair, err = c.verifyRelease(ctx, kr, p, body)
+		if err != nil {
+			log.Error("signature verification failed", map[string]interface{}{
+				"path":  p,
+				"error": err.Error(),
+			})
+			// keep the previous meta data in service.
+			statusCode = http.StatusBadGateway
+			return
+		}
+		err = c.checkRelease(p, rel)
+		if err != nil {
+			log.Error("rejected Release", map[string]interface{}{
+				"path":  p,
+				"error": err.Error(),
+			})
+			statusCode = http.StatusBadGateway
+			return
+		}
+		fil = addPrefix(t[0], rel.Files)
+	case kr != nil && isRelease(p):
+		// compressed Release files are not signed, hence
+		// they are neither cached nor served.
+		statusCode = http.StatusNotFound
+		return
+	default:
+		storage = c.meta
+		var d apt.Paragraph
+		fil, d, err = apt.ExtractFileInfo(t[1], bytes.NewReader(body))
 		if err != nil {
 			log.Error("invalid meta data", map[string]interface{}{
 				"path":  p,
 				"error": err.Error(),
 			})
 			// do not return; we accept broken meta data as is.
+		} else if isRelease(p) && d != nil {
+			var rel *apt.Release
+			rel, err = apt.ParseRelease(t[1], d)
+			if err == nil {
+				err = c.checkRelease(p, rel)
+			}
+			if err != nil {
+				log.Error("rejected Release", map[string]interface{}{
+					"path":  p,
+					"error": err.Error(),
+				})
+				// keep the previous meta data in service.
+				statusCode = http.StatusBadGateway
+				return
+			}
 		}
 		fil = addPrefix(t[0], fil)
 	}
 
+	files := []*signedFile{{fi, body}}
+	if pair != nil {
+		files = append(files, pair)
+	}
+
 	c.fiLock.Lock()
 	defer c.fiLock.Unlock()
 
-	if err := storage.Insert(body, fi); err != nil {
-		log.Error("could not save an item", map[string]interface{}{
-			"path":  p,
-			"error": err.Error(),
-		})
-		// panic because go-apt-cacher cannot continue working
-		panic(err)
+	for _, sf := range files {
+		if err := storage.Insert(sf.data, sf.fi); err != nil {
+			log.Error("could not save an item", map[string]interface{}{
+				"path":  sf.fi.Path(),
+				"error": err.Error(),
+			})
+			// panic because go-apt-cacher cannot continue working
+			panic(err)
+		}
 	}
 
 	for _, fi2 := range fil {
 		c.info[fi2.Path()] = fi2
 	}
-	if apt.IsMeta(p) {
-		_, ok := c.info[p]
-		if !ok {
-			// As this is the first time that downloaded meta file p,
-			c.maintMeta(p)
+	for _, sf := range files {
+		p2 := sf.fi.Path()
+		if apt.IsMeta(p2) {
+			_, ok := c.info[p2]
+			if !ok {
+				// As this is the first time that downloaded meta file p2,
+				c.maintMeta(p2)
+			}
 		}
+		c.info[p2] = sf.fi
+		log.Info("downloaded and cached", map[string]interface{}{
+			"path": p2,
+		})
+	}
+}
+
+// spoolItem writes a non-meta item from resp to sp while calculating
+// checksums, then caches the item if it is valid.
+//
+// This returns the HTTP status code to be cached for p.
+func (c *Cacher) spoolItem(p string, resp *http.Response, valid *apt.FileInfo, sp *spool) int {
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return resp.StatusCode
+	}
+
+	f, err := c.items.TempFile()
+	if err != nil {
+		log.Error("could not create a temporary file", map[string]interface{}{
+			"path":  p,
+			"error": err.Error(),
+		})
+		return http.StatusInternalServerError
+	}
+	inserted := false
+	defer func() {
+		f.Close()
+		if !inserted {
+			os.Remove(f.Name())
+		}
+	}()
+
+	sp.start(f, resp.ContentLength)
+
+	w := apt.NewFileInfoWriter(p)
+	_, err = io.Copy(io.MultiWriter(sp, w), resp.Body)
+	if err == nil {
+		err = f.Sync()
+	}
+	if err != nil {
+		log.Warn("GET failed", map[string]interface{}{
+			"url":   resp.Request.URL.String(),
+			"error": err.Error(),
+		})
+		sp.finish(err)
+		return resp.StatusCode
 	}
+
+	fi := w.FileInfo()
+	if valid != nil && !valid.Same(fi) {
+		log.Warn("downloaded data is not valid", map[string]interface{}{
+			"url": resp.Request.URL.String(),
+		})
+		sp.finish(errors.New("invalid checksum for " + p))
+		return resp.StatusCode
+	}
+
+	c.fiLock.Lock()
+	err = c.items.InsertFile(f.Name(), fi)
+	if err != nil {
+		log.Error("could not save an item", map[string]interface{}{
+			"path":  p,
+			"error": err.Error(),
+		})
+		// panic because go-apt-cacher cannot continue working
+		panic(err)
+	}
+	inserted = true
 	c.info[p] = fi
+	c.fiLock.Unlock()
+
+	sp.finish(nil)
 	log.Info("downloaded and cached", map[string]interface{}{
 		"path": p,
 	})
+	return resp.StatusCode
 }
 
 // Get looks up a cached item, and if not found, downloads it
 // from the upstream server.
 //
 // The return values are cached HTTP status code of the response from
-// an upstream server, a pointer to os.File for the cache file,
-// and error.
-func (c *Cacher) Get(p string) (statusCode int, f *os.File, err error) {
+// an upstream server, a reader for the item, and error.
+//
+// The reader is *os.File for the cache file if the item is cached.
+// Otherwise, the reader streams the item while it is being downloaded.
+// In this case, Read returns an error if the download fails or the
+// downloaded data turns out to be invalid.
+//
+// The caller is responsible to close the returned reader.
+func (c *Cacher) Get(p string) (statusCode int, f io.ReadCloser, err error) {
 	u := c.um.URL(p)
 	if u == nil {
 		return http.StatusNotFound, nil, nil
@@ -405,6 +628,16 @@ RETRY:
 	fi, ok := c.info[p]
 	c.fiLock.RUnlock()
 
+	if ok && fi.StrongestChecksum() < c.minChecksum {
+		// FileInfo.Same would accept data matching only by MD5 or size.
+		log.Error("weak checksum", map[string]interface{}{
+			"path":     p,
+			"checksum": fi.StrongestChecksum().String(),
+			"required": c.minChecksum.String(),
+		})
+		return http.StatusBadGateway, nil, nil
+	}
+
 	if ok {
 		f, err := storage.Lookup(fi)
 		switch err {
@@ -422,16 +655,21 @@ RETRY:
 	// not found in storage.
 	c.dlLock.RLock()
 	ch, chOk := c.dlChannels[p]
+	sp := c.spools[p]
 	result, resultOk := c.results[p]
 	c.dlLock.RUnlock()
 
 	if resultOk && result != http.StatusOK {
 		return result, nil, nil
 	}
-	if chOk {
-		<-ch
-	} else {
-		<-c.Download(p, fi)
+	if !chOk {
+		ch, sp = c.startDownload(p, fi)
+	}
+	if sp != nil {
+		if r := sp.newReader(); r != nil {
+			return http.StatusOK, r, nil
+		}
 	}
+	<-ch
 	goto RETRY
 }
@@ -0,0 +1,156 @@
+package cacher
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/cybozu-go/aptutil/apt"
+)
+
+func TestGetWeakChecksum(t *testing.T) {
+	t.Parallel()
+
+	u, err := url.Parse("http://localhost/ubuntu")
+	if err != nil {
+		t.Fatal(err)
+	}
+	um := make(URLMap)
+	if err := um.Register("ubuntu", u); err != nil {
+		t.Fatal(err)
+	}
+
+	fil, err := apt.FileInfoFromRelease("ubuntu/dists/trusty/Release", apt.Paragraph{
+		"MD5Sum": {"d41d8cd98f00b204e9800998ecf8427e 0 main/binary-amd64/Packages"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := &Cacher{
+		um:          um,
+		minChecksum: apt.ChecksumSHA256,
+		info: map[string]*apt.FileInfo{
+			fil[0].Path(): fil[0],
+		},
+	}
+
+	// must be refused without downloading anything.
+	status, f, err := c.Get(fil[0].Path())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status != http.StatusBadGateway {
+		t.Error(`status != http.StatusBadGateway`, status)
+	}
+	if f != nil {
+		t.Error(`f != nil`)
+	}
+}
+
+func TestGetStream(t *testing.T) {
+	t.Parallel()
+
+	data := bytes.Repeat([]byte("0123456789"), 10000)
+	release := make(chan struct{})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+		w.Write(data[:len(data)/2])
+		w.(http.Flusher).Flush()
+		<-release
+		w.Write(data[len(data)/2:])
+	}))
+	defer ts.Close()
+
+	u, err := url.Parse(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	um := make(URLMap)
+	if err := um.Register("test", u); err != nil {
+		t.Fatal(err)
+	}
+
+	d, err := ioutil.TempDir("", "gotest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(d)
+
+	c := &Cacher{
+		items:       NewStorage(d, 0),
+		um:          um,
+		cachePeriod: time.Second,
+		client:      &http.Client{},
+		info: map[string]*apt.FileInfo{
+			"test/bad.deb": apt.MakeFileInfo("test/bad.deb", []byte("bad")),
+		},
+		dlChannels: make(map[string]chan struct{}),
+		spools:     make(map[string]*spool),
+		results:    make(map[string]int),
+	}
+
+	status, f, err := c.Get("test/a.deb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status != http.StatusOK {
+		t.Fatal(`status != http.StatusOK`, status)
+	}
+	sr, ok := f.(*spoolReader)
+	if !ok {
+		t.Fatal(`f is not *spoolReader`)
+	}
+	if sr.Size() != int64(len(data)) {
+		t.Error(`sr.Size() != int64(len(data))`)
+	}
+
+	// the first half can be read before the download completes.
+	buf := make([]byte, len(data)/2-1)
+	if _, err := io.ReadFull(sr, buf); err != nil {
+		t.Fatal(err)
+	}
+	close(release)
+	rest, err := ioutil.ReadAll(sr)
+	sr.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(append(buf, rest...), data) {
+		t.Error(`streamed data differ`)
+	}
+
+	// now the item is cached.
+	status, f, err = c.Get("test/a.deb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status != http.StatusOK {
+		t.Fatal(`status != http.StatusOK`, status)
+	}
+	if _, ok := f.(*os.File); !ok {
+		t.Error(`f is not *os.File`)
+	}
+	f.Close()
+
+	// invalid data must be reported to readers.
+	status, f, err = c.Get("test/bad.deb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status != http.StatusOK {
+		t.Fatal(`status != http.StatusOK`, status)
+	}
+	_, err = ioutil.ReadAll(f)
+	f.Close()
+	if err == nil {
+		t.Error(`invalid data must not be read successfully`)
+	}
+}
@@ -62,6 +62,19 @@ type Config struct {
 
 	// Mapping specifies mapping between prefixes and APT URLs.
 	Mapping map[string]string `toml:"mapping"`
+
+	// Keyring specifies OpenPGP keyring files for prefixes in Mapping.
+	//
+	// If a keyring is specified for a prefix, Release and InRelease
+	// for the prefix are cached only when their signatures are valid.
+	Keyring map[string]string `toml:"keyring"`
+
+	// MinChecksum specifies the weakest checksum algorithm trusted
+	// to validate indices and items, e.g. "sha256".
+	//
+	// Files whose checksums in indices are all weaker than this
+	// are not served.  Default is to trust any checksums or size.
+	MinChecksum string `toml:"min_checksum"`
 }
 
 // NewConfig creates Config with default values.
@@ -51,4 +51,15 @@ func TestConfig(t *testing.T) {
 	if config.Mapping["dell"] != "http://linux.dell.com/repo/community/ubuntu" {
 		t.Error(`config.Mapping["dell"]`)
 	}
+
+	if config.Keyring["ubuntu"] != "/usr/share/keyrings/ubuntu-archive-keyring.gpg" {
+		t.Error(`config.Keyring["ubuntu"]`)
+	}
+	if _, ok := config.Keyring["dell"]; ok {
+		t.Error(`config.Keyring["dell"]`)
+	}
+
+	if config.MinChecksum != "sha256" {
+		t.Error(`config.MinChecksum != "sha256"`)
+	}
 }
@@ -2,8 +2,10 @@ package cacher
 
 import (
 	"fmt"
+	"io"
 	"mime"
 	"net/http"
+	"os"
 	"path"
 	"strconv"
 	"time"
@@ -44,23 +46,55 @@ func (c cacheHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	default:
 		// http.StatusOK
 		defer f.Close()
+		if sr, ok := f.(*spoolReader); ok {
+			serveSpool(w, r, p, sr)
+			return
+		}
 		if r.Method == "GET" {
 			var zeroTime time.Time
-			http.ServeContent(w, r, path.Base(p), zeroTime, f)
+			http.ServeContent(w, r, path.Base(p), zeroTime, f.(*os.File))
 			return
 		}
-		stat, err := f.Stat()
+		stat, err := f.(*os.File).Stat()
 		if err != nil {
 			status = http.StatusInternalServerError
 			http.Error(w, err.Error(), status)
 			return
 		}
-		ct := mime.TypeByExtension(path.Ext(p))
-		if ct == "" {
-			ct = "application/octet-stream"
-		}
-		w.Header().Set("Content-Type", ct)
-		w.Header().Set("Content-Length", strconv.FormatInt(stat.Size(), 10))
+		setContentHeaders(w, p, stat.Size())
 		w.WriteHeader(http.StatusOK)
 	}
 }
+
+func setContentHeaders(w http.ResponseWriter, p string, size int64) {
+	ct := mime.TypeByExtension(path.Ext(p))
+	if ct == "" {
+		ct = "application/octet-stream"
+	}
+	w.Header().Set("Content-Type", ct)
+	if size >= 0 {
+		w.Header().Set("Content-Length", strconv.FormatInt(size, 10))
+	}
+}
+
+// serveSpool serves an item being downloaded.
+//
+// Range requests are not supported; the whole item is always returned.
+func serveSpool(w http.ResponseWriter, r *http.Request, p string, sr *spoolReader) {
+	setContentHeaders(w, p, sr.Size())
+	w.WriteHeader(http.StatusOK)
+	if r.Method != "GET" {
+		return
+	}
+
+	_, err := io.Copy(w, sr)
+	if err != nil {
+		log.Error("streaming failed", map[string]interface{}{
+			"path":  p,
+			"error": err.Error(),
+		})
+		// abort the connection so that the client can notice
+		// the failure.
+		panic(http.ErrAbortHandler)
+	}
+}
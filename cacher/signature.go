@@ -0,0 +1,248 @@
+package cacher
+
+// This file implements verification of Release and InRelease,
+// namely signatures and freshness.
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/cybozu-go/aptutil/apt"
+	"github.com/cybozu-go/log"
+	"github.com/pkg/errors"
+)
+
+// signedFile is a pair of FileInfo and its data.
+type signedFile struct {
+	fi   *apt.FileInfo
+	data []byte
+}
+
+// isRelease returns true if p points Release, Release.gpg, InRelease,
+// or their compressed variants.
+func isRelease(p string) bool {
+	base := path.Base(p)
+	base = base[0 : len(base)-len(path.Ext(base))]
+	switch base {
+	case "Release", "InRelease":
+		return true
+	}
+	return false
+}
+
+// isSignedRelease returns true if p points Release, Release.gpg,
+// or InRelease whose signature can be verified.
+func isSignedRelease(p string) bool {
+	switch path.Base(p) {
+	case "Release", "Release.gpg", "InRelease":
+		return true
+	}
+	return false
+}
+
+// keyring returns *apt.Keyring for the prefix of p, or nil.
+func (c *Cacher) keyring(p string) *apt.Keyring {
+	t := strings.SplitN(path.Clean(p), "/", 2)
+	return c.keyrings[t[0]]
+}
+
+// fetch downloads u.  Responses other than 200 OK are errors.
+func (c *Cacher) fetch(ctx context.Context, u *url.URL) ([]byte, error) {
+	req := &http.Request{
+		Method:     "GET",
+		URL:        u,
+		Proto:      "HTTP/1.1",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Header:     make(http.Header),
+	}
+	resp, err := c.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.Errorf("status %d for %s", resp.StatusCode, u.String())
+	}
+	return body, nil
+}
+
+// verifyRelease verifies the signature of Release, Release.gpg,
+// or InRelease at p, whose contents is data.
+//
+// As Release and Release.gpg are signed as a pair, the counterpart
+// is downloaded from the upstream and returned if the signature is valid.
+//
+// The returned *apt.Release is parsed from the verified data.
+// Its Files do not have the prefix of p yet.
+func (c *Cacher) verifyRelease(ctx context.Context, kr *apt.Keyring, p string, data []byte) (*apt.Release, *signedFile, error) {
+	t := strings.SplitN(path.Clean(p), "/", 2)
+
+	if path.Base(p) == "InRelease" {
+		fpr, d, err := kr.VerifyInRelease(data)
+		if err != nil {
+			return nil, nil, err
+		}
+		log.Info("verified signature", map[string]interface{}{
+			"path":        p,
+			"fingerprint": fpr,
+		})
+		rel, err := apt.ParseRelease(t[1], d)
+		return rel, nil, err
+	}
+
+	release, sig, pair := data, []byte(nil), p+".gpg"
+	if path.Base(p) == "Release.gpg" {
+		pair = strings.TrimSuffix(p, ".gpg")
+	}
+	pairData, err := c.fetch(ctx, c.um.URL(pair))
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "fetch "+pair)
+	}
+	if path.Base(p) == "Release" {
+		sig = pairData
+	} else {
+		release, sig = pairData, data
+	}
+
+	fpr, d, err := kr.VerifyRelease(release, sig)
+	if err != nil {
+		return nil, nil, err
+	}
+	log.Info("verified signature", map[string]interface{}{
+		"path":        p,
+		"fingerprint": fpr,
+	})
+
+	// only the directory of t[1] matters.
+	rel, err := apt.ParseRelease(t[1], d)
+	if err != nil {
+		return nil, nil, err
+	}
+	return rel, &signedFile{apt.MakeFileInfo(pair, pairData), pairData}, nil
+}
+
+// checkRelease rejects rel if it has expired or if its Date goes
+// backwards compared to the cached Release at p.
+func (c *Cacher) checkRelease(p string, rel *apt.Release) error {
+	err := rel.CheckFresh(time.Now())
+	if err != nil {
+		return err
+	}
+
+	p = strings.TrimSuffix(p, ".gpg")
+	metas := make(map[string]*apt.FileInfo)
+	c.fiLock.RLock()
+	for _, p2 := range []string{p, p + ".gpg"} {
+		if fi, ok := c.info[p2]; ok {
+			metas[p2] = fi
+		}
+	}
+	c.fiLock.RUnlock()
+
+	d, err := c.readRelease(p, metas)
+	if err != nil {
+		return nil
+	}
+	t := strings.SplitN(p, "/", 2)
+	old, err := apt.ParseRelease(t[1], d)
+	if err != nil {
+		return nil
+	}
+	return rel.CheckNewer(old)
+}
+
+// readRelease reads the paragraph of the cached Release or InRelease
+// at p.  metas is used to look up cached files.
+//
+// If the prefix of p has a keyring, the signature is verified again
+// so that only the signed text is trusted.  Otherwise, only the signed
+// text of InRelease is read.
+func (c *Cacher) readRelease(p string, metas map[string]*apt.FileInfo) (apt.Paragraph, error) {
+	read := func(p string) ([]byte, error) {
+		fi, ok := metas[p]
+		if !ok {
+			return nil, ErrNotFound
+		}
+		f, err := c.meta.Lookup(fi)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		return ioutil.ReadAll(f)
+	}
+
+	data, err := read(p)
+	if err != nil {
+		return nil, err
+	}
+
+	var d apt.Paragraph
+	kr := c.keyring(p)
+	switch {
+	case kr != nil && path.Base(p) == "InRelease":
+		_, d, err = kr.VerifyInRelease(data)
+	case kr != nil && path.Base(p) == "Release":
+		var sig []byte
+		sig, err = read(p + ".gpg")
+		if err != nil {
+			return nil, err
+		}
+		_, d, err = kr.VerifyRelease(data, sig)
+	case kr != nil:
+		return nil, errors.New("not signed: " + p)
+	default:
+		if path.Base(p) == "InRelease" {
+			data, err = apt.SignedText(data)
+			if err != nil {
+				return nil, err
+			}
+		}
+		t := strings.SplitN(p, "/", 2)
+		_, d, err = apt.ExtractFileInfo(t[1], bytes.NewReader(data))
+	}
+	if err != nil {
+		return nil, err
+	}
+	if d == nil {
+		return nil, errors.New("empty Release: " + p)
+	}
+	return d, nil
+}
+
+// loadRelease verifies the cached Release, Release.gpg, or InRelease
+// at p whose prefix has a keyring, and returns the checksums in it.
+// metas is used to look up cached files.
+//
+// Compressed variants are never trusted as they are not signed.
+func (c *Cacher) loadRelease(p string, metas map[string]*apt.FileInfo) ([]*apt.FileInfo, error) {
+	if !isSignedRelease(p) {
+		return nil, errors.New("not signed: " + p)
+	}
+	if path.Base(p) == "Release.gpg" {
+		// checksums are taken from the paired Release.
+		_, err := c.readRelease(strings.TrimSuffix(p, ".gpg"), metas)
+		return nil, err
+	}
+
+	d, err := c.readRelease(p, metas)
+	if err != nil {
+		return nil, err
+	}
+	t := strings.SplitN(p, "/", 2)
+	rel, err := apt.ParseRelease(t[1], d)
+	if err != nil {
+		return nil, err
+	}
+	return addPrefix(t[0], rel.Files), nil
+}
@@ -0,0 +1,197 @@
+package cacher
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/cybozu-go/aptutil/apt"
+)
+
+func TestVerifyRelease(t *testing.T) {
+	t.Parallel()
+
+	ts := httptest.NewServer(http.FileServer(http.Dir("../apt/testdata/gpg")))
+	defer ts.Close()
+
+	u, err := url.Parse(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	um := make(URLMap)
+	if err := um.Register("test", u); err != nil {
+		t.Fatal(err)
+	}
+
+	kr, err := apt.LoadKeyring("../apt/testdata/gpg/keyring.asc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := &Cacher{
+		um:       um,
+		client:   &http.Client{},
+		keyrings: map[string]*apt.Keyring{"test": kr},
+	}
+	if c.keyring("test/Release") != kr {
+		t.Error(`c.keyring("test/Release") != kr`)
+	}
+
+	ctx := context.Background()
+	read := func(fn string) []byte {
+		data, err := ioutil.ReadFile("../apt/testdata/gpg/" + fn)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return data
+	}
+
+	rel, pair, err := c.verifyRelease(ctx, kr, "test/InRelease", read("InRelease"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rel.Files) != 9 {
+		t.Error(`len(fil) != 9`)
+	}
+	if pair != nil {
+		t.Error(`pair != nil`)
+	}
+
+	_, _, err = c.verifyRelease(ctx, kr, "test/InRelease", read("InRelease.other"))
+	if err == nil {
+		t.Error(`InRelease signed by unknown key must not be verified`)
+	}
+
+	rel, pair, err = c.verifyRelease(ctx, kr, "test/Release", read("Release"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rel.Files) != 9 {
+		t.Error(`len(fil) != 9`)
+	}
+	if pair == nil || pair.fi.Path() != "test/Release.gpg" {
+		t.Error(`pair.fi.Path() != "test/Release.gpg"`)
+	}
+
+	rel, pair, err = c.verifyRelease(ctx, kr, "test/Release.gpg", read("Release.gpg"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rel.Files) != 9 {
+		t.Error(`len(fil) != 9`)
+	}
+	if strings.HasPrefix(rel.Files[0].Path(), "test/") {
+		t.Error(`fil must not have the prefix`)
+	}
+	if pair == nil || pair.fi.Path() != "test/Release" {
+		t.Error(`pair.fi.Path() != "test/Release"`)
+	}
+
+	_, _, err = c.verifyRelease(ctx, kr, "test/Release", read("InRelease"))
+	if err == nil {
+		t.Error(`Release with wrong signature must not be verified`)
+	}
+
+	if !isSignedRelease("test/dists/trusty/Release.gpg") {
+		t.Error(`!isSignedRelease("test/dists/trusty/Release.gpg")`)
+	}
+	if isSignedRelease("test/dists/trusty/Release.gz") {
+		t.Error(`isSignedRelease("test/dists/trusty/Release.gz")`)
+	}
+	if !isRelease("test/dists/trusty/InRelease.bz2") {
+		t.Error(`!isRelease("test/dists/trusty/InRelease.bz2")`)
+	}
+	if isRelease("test/dists/trusty/main/binary-amd64/Packages") {
+		t.Error(`isRelease("test/dists/trusty/main/binary-amd64/Packages")`)
+	}
+}
+
+func TestLoadRelease(t *testing.T) {
+	t.Parallel()
+
+	d, err := ioutil.TempDir("", "gotest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(d)
+
+	read := func(fn string) []byte {
+		data, err := ioutil.ReadFile("../apt/testdata/gpg/" + fn)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return data
+	}
+	tampered := bytes.Replace(read("InRelease"),
+		[]byte("Codename: testing"), []byte("Codename: hacked!"), 1)
+	var gz bytes.Buffer
+	w := gzip.NewWriter(&gz)
+	w.Write(read("Release"))
+	w.Close()
+
+	metaDir := filepath.Join(d, "meta")
+	for _, dir := range []string{metaDir, filepath.Join(d, "cache")} {
+		if err := os.Mkdir(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	meta := NewStorage(metaDir, 0)
+	for p, data := range map[string][]byte{
+		"test/dists/testing/InRelease":   read("InRelease"),
+		"test/dists/stable/InRelease":    tampered,
+		"test/dists/stable/Release":      read("Release"),
+		"test/dists/stable/Release.gpg":  read("Release.gpg"),
+		"test/dists/unstable/Release":    read("Release"),
+		"test/dists/unstable/Release.gz": gz.Bytes(),
+	} {
+		if err := meta.Insert(data, apt.MakeFileInfo(p, data)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	c, err := NewCacher(&Config{
+		CheckInterval:  3600,
+		MetaDirectory:  metaDir,
+		CacheDirectory: filepath.Join(d, "cache"),
+		CacheCapacity:  1,
+		Mapping:        map[string]string{"test": "http://localhost/"},
+		Keyring:        map[string]string{"test": "../apt/testdata/gpg/keyring.asc"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, p := range []string{
+		"test/dists/testing/InRelease",
+		"test/dists/stable/Release",
+		"test/dists/stable/Release.gpg",
+	} {
+		if _, ok := c.info[p]; !ok {
+			t.Error(`verified file must be loaded:`, p)
+		}
+	}
+	for _, p := range []string{
+		"test/dists/stable/InRelease",
+		"test/dists/unstable/Release",
+		"test/dists/unstable/Release.gz",
+	} {
+		if _, ok := c.info[p]; ok {
+			t.Error(`unverified file must not be loaded:`, p)
+		}
+		for _, fi := range c.meta.ListAll() {
+			if fi.Path() == p {
+				t.Error(`unverified file must be removed:`, p)
+			}
+		}
+	}
+	if _, ok := c.info["test/dists/testing/main/binary-amd64/Packages"]; !ok {
+		t.Error(`checksums in verified Release must be loaded`)
+	}
+}
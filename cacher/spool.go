@@ -0,0 +1,164 @@
+package cacher
+
+// This file implements spools to serve items to clients while
+// they are being downloaded from upstream servers.
+
+import (
+	"io"
+	"os"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	// errSpoolAborted is returned to readers of a spool whose
+	// download failed before it started.
+	errSpoolAborted = errors.New("download aborted")
+)
+
+// spool is a temporary file written by a download goroutine and
+// read concurrently by clients waiting for the item.
+//
+// Readers never receive the last byte until the spool is finished
+// successfully, i.e. the downloaded data is validated and cached.
+// This way, clients can detect invalid data by aborted connections
+// even if they know Content-Length.
+type spool struct {
+	mu   sync.Mutex
+	cond *sync.Cond
+
+	started bool
+	done    bool
+	err     error
+
+	f      *os.File
+	length int64 // Content-Length of the item, or -1 if unknown.
+	size   int64 // bytes written so far.
+}
+
+func newSpool() *spool {
+	s := new(spool)
+	s.cond = sync.NewCond(&s.mu)
+	return s
+}
+
+// start makes the spool available to readers.
+// f is the temporary file to be written, and length is the expected
+// size of the item, or -1 if unknown.
+func (s *spool) start(f *os.File, length int64) {
+	s.mu.Lock()
+	s.f = f
+	s.length = length
+	s.started = true
+	s.mu.Unlock()
+	s.cond.Broadcast()
+}
+
+// Write implements io.Writer.
+func (s *spool) Write(p []byte) (int, error) {
+	n, err := s.f.Write(p)
+
+	s.mu.Lock()
+	s.size += int64(n)
+	s.mu.Unlock()
+	s.cond.Broadcast()
+	return n, err
+}
+
+// finish finishes the spool.  If err is not nil, readers will
+// receive err.  Calling finish more than once is no-op.
+func (s *spool) finish(err error) {
+	s.mu.Lock()
+	if s.done {
+		s.mu.Unlock()
+		return
+	}
+	s.done = true
+	s.err = err
+	s.mu.Unlock()
+	s.cond.Broadcast()
+}
+
+// newReader waits for the spool to start, then returns a reader for it.
+//
+// nil is returned if the spool finished before it started, or if
+// it has already finished.  In such cases, callers should look up
+// the item in the cache instead.
+func (s *spool) newReader() *spoolReader {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for !s.started && !s.done {
+		s.cond.Wait()
+	}
+	if s.done {
+		return nil
+	}
+
+	// The file is opened while the spool is in progress, so the
+	// file is never renamed or removed yet.
+	f, err := os.Open(s.f.Name())
+	if err != nil {
+		return nil
+	}
+	return &spoolReader{s: s, f: f}
+}
+
+// spoolReader reads data from a spool.
+type spoolReader struct {
+	s   *spool
+	f   *os.File
+	off int64
+}
+
+// Size returns the expected size of the item, or -1 if unknown.
+func (r *spoolReader) Size() int64 {
+	return r.s.length
+}
+
+// Read implements io.Reader.
+func (r *spoolReader) Read(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+
+	s := r.s
+	s.mu.Lock()
+	var avail int64
+	for {
+		if s.err != nil {
+			s.mu.Unlock()
+			return 0, s.err
+		}
+		avail = s.size
+		if !s.done {
+			// hold the last byte until the data is validated.
+			avail--
+		}
+		if r.off < avail {
+			break
+		}
+		if s.done {
+			s.mu.Unlock()
+			return 0, io.EOF
+		}
+		s.cond.Wait()
+	}
+	s.mu.Unlock()
+
+	if n := avail - r.off; int64(len(p)) > n {
+		p = p[:n]
+	}
+	n, err := r.f.ReadAt(p, r.off)
+	r.off += int64(n)
+	if err == io.EOF && n > 0 {
+		err = nil
+	}
+	return n, err
+}
+
+// Close implements io.Closer.
+func (r *spoolReader) Close() error {
+	return r.f.Close()
+}
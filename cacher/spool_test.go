@@ -0,0 +1,109 @@
+package cacher
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func testSpool(t *testing.T, d string) (*spool, *os.File) {
+	f, err := ioutil.TempFile(d, "_tmp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sp := newSpool()
+	sp.start(f, 6)
+	return sp, f
+}
+
+func TestSpool(t *testing.T) {
+	t.Parallel()
+
+	d, err := ioutil.TempDir("", "gotest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(d)
+
+	sp, f := testSpool(t, d)
+	defer f.Close()
+
+	r := sp.newReader()
+	if r == nil {
+		t.Fatal(`r == nil`)
+	}
+	defer r.Close()
+	if r.Size() != 6 {
+		t.Error(`r.Size() != 6`)
+	}
+
+	if _, err := sp.Write([]byte("abc")); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 10)
+	n, err := r.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the last byte should be held.
+	if string(buf[:n]) != "ab" {
+		t.Error(`string(buf[:n]) != "ab"`, string(buf[:n]))
+	}
+
+	if _, err := sp.Write([]byte("def")); err != nil {
+		t.Fatal(err)
+	}
+	sp.finish(nil)
+	rest, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(rest) != "cdef" {
+		t.Error(`string(rest) != "cdef"`, string(rest))
+	}
+
+	// finished spools should not be read.
+	if sp.newReader() != nil {
+		t.Error(`sp.newReader() != nil`)
+	}
+}
+
+func TestSpoolAbort(t *testing.T) {
+	t.Parallel()
+
+	d, err := ioutil.TempDir("", "gotest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(d)
+
+	sp, f := testSpool(t, d)
+	defer f.Close()
+
+	r := sp.newReader()
+	if r == nil {
+		t.Fatal(`r == nil`)
+	}
+	defer r.Close()
+
+	data := bytes.Repeat([]byte{'a'}, 6)
+	if _, err := sp.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	errBad := errors.New("bad")
+	sp.finish(errBad)
+
+	_, err = ioutil.ReadAll(r)
+	if err != errBad {
+		t.Error(`err != errBad`, err)
+	}
+
+	// spools finished before started should not be read.
+	sp = newSpool()
+	sp.finish(errSpoolAborted)
+	if sp.newReader() != nil {
+		t.Error(`sp.newReader() != nil`)
+	}
+}
@@ -186,22 +186,20 @@ func (cm *Storage) Load() error {
 	return nil
 }
 
+// TempFile creates a new temporary file in the cache directory.
+//
+// The file can be inserted into the cache by InsertFile.
+// The caller is responsible for removing the file if it is not inserted.
+func (cm *Storage) TempFile() (*os.File, error) {
+	return ioutil.TempFile(cm.dir, "_tmp")
+}
+
 // Insert inserts or updates a cache item.
 //
 // fi.Path() must be as clean as filepath.Clean() and
 // must not be filepath.IsAbs().
 func (cm *Storage) Insert(data []byte, fi *apt.FileInfo) error {
-	p := fi.Path()
-	switch {
-	case p != filepath.Clean(p):
-		return ErrBadPath
-	case filepath.IsAbs(p):
-		return ErrBadPath
-	case p == ".":
-		return ErrBadPath
-	}
-
-	f, err := ioutil.TempFile(cm.dir, "_tmp")
+	f, err := cm.TempFile()
 	if err != nil {
 		return err
 	}
@@ -219,10 +217,29 @@ func (cm *Storage) Insert(data []byte, fi *apt.FileInfo) error {
 		return err
 	}
 
+	return cm.InsertFile(f.Name(), fi)
+}
+
+// InsertFile inserts or updates a cache item by renaming a file
+// created by TempFile.  The file should have been synced.
+//
+// fi.Path() must be as clean as filepath.Clean() and
+// must not be filepath.IsAbs().
+func (cm *Storage) InsertFile(name string, fi *apt.FileInfo) error {
+	p := fi.Path()
+	switch {
+	case p != filepath.Clean(p):
+		return ErrBadPath
+	case filepath.IsAbs(p):
+		return ErrBadPath
+	case p == ".":
+		return ErrBadPath
+	}
+
 	destpath := filepath.Join(cm.dir, p+fileSuffix)
 	dirpath := filepath.Dir(destpath)
 
-	_, err = os.Stat(dirpath)
+	_, err := os.Stat(dirpath)
 	switch {
 	case os.IsNotExist(err):
 		err = os.MkdirAll(dirpath, 0755)
@@ -256,7 +273,7 @@ func (cm *Storage) Insert(data []byte, fi *apt.FileInfo) error {
 		}
 	}
 
-	err = os.Rename(f.Name(), destpath)
+	err = os.Rename(name, destpath)
 	if err != nil {
 		return err
 	}
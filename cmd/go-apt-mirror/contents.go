@@ -0,0 +1,63 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/cybozu-go/aptutil/mirror"
+	"github.com/pkg/errors"
+)
+
+const contentsCommand = "contents"
+
+// runContents implements "contents" subcommand that searches
+// Contents indices of a mirror for files like apt-file.
+func runContents(c *mirror.Config, args []string) error {
+	fs := flag.NewFlagSet(contentsCommand, flag.ContinueOnError)
+	useRegexp := fs.Bool("regexp", false, "treat PATTERN as a regular expression")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: go-apt-mirror [options] contents [-regexp] MIRROR PATTERN")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 2 {
+		fs.Usage()
+		return errors.New("wrong number of arguments")
+	}
+	id, pattern := fs.Arg(0), fs.Arg(1)
+
+	match := func(p string) bool {
+		return strings.Contains(p, pattern)
+	}
+	if *useRegexp {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return errors.Wrap(err, "bad pattern: "+pattern)
+		}
+		match = re.MatchString
+	}
+
+	results, err := mirror.SearchContents(c, id, match)
+	if err != nil {
+		return err
+	}
+
+	// the same file may be listed in several indices.
+	seen := make(map[string]bool)
+	for _, r := range results {
+		for _, pkg := range r.Packages {
+			l := pkg + ": " + r.Path
+			if seen[l] {
+				continue
+			}
+			seen[l] = true
+			fmt.Println(l)
+		}
+	}
+	return nil
+}
@@ -8,6 +8,7 @@ import (
 	"github.com/BurntSushi/toml"
 	"github.com/cybozu-go/aptutil/mirror"
 	"github.com/cybozu-go/log"
+	"github.com/pkg/errors"
 )
 
 const (
@@ -38,7 +39,21 @@ func main() {
 		log.ErrorExit(err)
 	}
 
-	err = mirror.Run(config, flag.Args())
+	// a mirror of this ID could not be specified in arguments.
+	if _, ok := config.Mirrors[contentsCommand]; ok {
+		log.ErrorExit(errors.New("mirror ID must not be " + contentsCommand))
+	}
+
+	args := flag.Args()
+	if len(args) > 0 && args[0] == contentsCommand {
+		err = runContents(config, args[1:])
+		if err != nil {
+			log.ErrorExit(err)
+		}
+		return
+	}
+
+	err = mirror.Run(config, args)
 	if err != nil {
 		log.ErrorExit(err)
 	}
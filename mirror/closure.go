@@ -0,0 +1,224 @@
+package mirror
+
+// This file implements dependency-closure mirroring, that mirrors
+// only specified packages and packages they depend on.
+//
+// Dependencies are resolved by package names.  Version constraints
+// are not considered, therefore all versions of dependencies are kept.
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/cybozu-go/aptutil/apt"
+	"github.com/cybozu-go/log"
+)
+
+var (
+	// fields of binary packages followed to resolve dependencies.
+	closureFields = []string{"Pre-Depends", "Depends"}
+)
+
+// fieldValue returns the value of a field, joining continuation lines.
+func fieldValue(d apt.Paragraph, name string) string {
+	v, _ := d.Get(name)
+	return strings.Join(v, " ")
+}
+
+// relations returns package names of each group of alternatives
+// in a relationship field of a package.
+// Version constraints and architecture qualifiers are ignored.
+//
+// Malformed fields are ignored with a warning.
+func (m *Mirror) relations(s *stanza, field string) [][]string {
+	rels, err := apt.ParseRelations(fieldValue(s.d, field))
+	if err != nil {
+		log.Warn("bad relationship field", map[string]interface{}{
+			"repo":    m.id,
+			"package": fieldValue(s.d, "Package"),
+			"field":   field,
+			"error":   err.Error(),
+		})
+		return nil
+	}
+
+	names := make([][]string, 0, len(rels))
+	for _, alts := range rels {
+		l := make([]string, 0, len(alts))
+		for _, r := range alts {
+			l = append(l, r.Name)
+		}
+		names = append(names, l)
+	}
+	return names
+}
+
+// sourceName returns the name of the source package of a binary package.
+func sourceName(d apt.Paragraph) string {
+	if src := strings.Fields(fieldValue(d, "Source")); len(src) > 0 {
+		return src[0]
+	}
+	return fieldValue(d, "Package")
+}
+
+// packageUniverse indexes binary packages installable on an architecture.
+type packageUniverse struct {
+	packages map[string][]*stanza
+	provides map[string][]*stanza
+}
+
+func (m *Mirror) newPackageUniverse(binaries []*stanza, arch string) *packageUniverse {
+	u := &packageUniverse{
+		packages: make(map[string][]*stanza),
+		provides: make(map[string][]*stanza),
+	}
+	for _, s := range binaries {
+		a := fieldValue(s.d, "Architecture")
+		if a != arch && a != "all" {
+			continue
+		}
+		name := fieldValue(s.d, "Package")
+		if len(name) == 0 {
+			continue
+		}
+		u.packages[name] = append(u.packages[name], s)
+		for _, alts := range m.relations(s, "Provides") {
+			for _, p := range alts {
+				u.provides[p] = append(u.provides[p], s)
+			}
+		}
+	}
+	return u
+}
+
+// lookup returns packages named name.
+//
+// If no real package exists, name is a virtual package and packages
+// of one of its providers are returned.  A provider already in selected
+// is preferred, or the first one in indices is chosen.
+func (u *packageUniverse) lookup(name string, selected map[*stanza]bool) []*stanza {
+	if sl := u.packages[name]; len(sl) > 0 {
+		return sl
+	}
+
+	providers := u.provides[name]
+	if len(providers) == 0 {
+		return nil
+	}
+	provider := providers[0]
+	for _, s := range providers {
+		if selected[s] {
+			provider = s
+			break
+		}
+	}
+	return u.packages[fieldValue(provider.d, "Package")]
+}
+
+// closureArchitectures returns architectures to resolve dependencies.
+// For flat repositories, architectures are taken from packages.
+func closureArchitectures(archs []string, binaries []*stanza) []string {
+	if len(archs) > 0 {
+		return archs
+	}
+
+	m := make(map[string]bool)
+	for _, s := range binaries {
+		a := fieldValue(s.d, "Architecture")
+		if len(a) > 0 && a != "all" {
+			m[a] = true
+		}
+	}
+	for a := range m {
+		archs = append(archs, a)
+	}
+	sort.Strings(archs)
+	if len(archs) == 0 {
+		archs = []string{"all"}
+	}
+	return archs
+}
+
+// resolveClosure keeps only the dependency closure of the configured
+// packages among packages kept in groups.
+//
+// For each group of alternatives, the first one available is followed.
+// A virtual package is resolved to only one provider; see lookup.
+// Source packages are kept if any of their binary packages are kept.
+func (m *Mirror) resolveClosure(groups []*indexGroup) {
+	var binaries []*stanza
+	for _, g := range groups {
+		if g.source {
+			continue
+		}
+		for _, s := range g.stanzas {
+			if s.keep {
+				binaries = append(binaries, s)
+			}
+		}
+	}
+
+	fields := closureFields
+	if m.mc.Recommends {
+		fields = append([]string{"Recommends"}, fields...)
+	}
+
+	selected := make(map[*stanza]bool)
+	found := make(map[string]bool)
+	for _, arch := range closureArchitectures(m.mc.Architectures, binaries) {
+		u := m.newPackageUniverse(binaries, arch)
+		visited := make(map[string]bool)
+		queue := append([]string(nil), m.mc.Packages...)
+		for len(queue) > 0 {
+			name := queue[0]
+			queue = queue[1:]
+			if visited[name] {
+				continue
+			}
+			visited[name] = true
+
+			for _, s := range u.lookup(name, selected) {
+				selected[s] = true
+				for _, field := range fields {
+					for _, alts := range m.relations(s, field) {
+						for _, alt := range alts {
+							if len(u.lookup(alt, selected)) > 0 {
+								queue = append(queue, alt)
+								break
+							}
+						}
+					}
+				}
+			}
+		}
+
+		for _, pkg := range m.mc.Packages {
+			if len(u.lookup(pkg, selected)) > 0 {
+				found[pkg] = true
+			}
+		}
+	}
+
+	for _, pkg := range m.mc.Packages {
+		if !found[pkg] {
+			log.Warn("package not found", map[string]interface{}{
+				"repo":    m.id,
+				"package": pkg,
+			})
+		}
+	}
+
+	sources := make(map[string]bool)
+	for s := range selected {
+		sources[sourceName(s.d)] = true
+	}
+	for _, g := range groups {
+		for _, s := range g.stanzas {
+			if g.source {
+				s.keep = s.keep && sources[fieldValue(s.d, "Package")]
+			} else {
+				s.keep = selected[s]
+			}
+		}
+	}
+}
@@ -0,0 +1,147 @@
+package mirror
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/cybozu-go/aptutil/apt"
+)
+
+func TestRelations(t *testing.T) {
+	t.Parallel()
+
+	m := &Mirror{id: "test"}
+	s := testStanza("Package", "app",
+		"Depends", "libc6 (>= 2.14), python3:any | python3-minimal, debconf (>= 0.5) | debconf-2.0,",
+		"Recommends", "foo (>= )")
+	rel := m.relations(s, "Depends")
+	expected := [][]string{
+		{"libc6"},
+		{"python3", "python3-minimal"},
+		{"debconf", "debconf-2.0"},
+	}
+	if !reflect.DeepEqual(rel, expected) {
+		t.Error(`!reflect.DeepEqual(rel, expected)`, rel)
+	}
+
+	if len(m.relations(s, "Pre-Depends")) != 0 {
+		t.Error(`len(m.relations(s, "Pre-Depends")) != 0`)
+	}
+	if len(m.relations(s, "Recommends")) != 0 {
+		t.Error(`malformed fields must be ignored`)
+	}
+}
+
+func testStanza(fields ...string) *stanza {
+	d := make(apt.Paragraph)
+	for i := 0; i < len(fields); i += 2 {
+		d[fields[i]] = []string{fields[i+1]}
+	}
+	return &stanza{d: d, keep: true}
+}
+
+func TestResolveClosure(t *testing.T) {
+	t.Parallel()
+
+	app := testStanza("Package", "app", "Architecture", "amd64", "Source", "app-src (1.0)",
+		"Depends", "libfoo (>= 1.0), mail-transport-agent | postfix", "Recommends", "app-doc")
+	appI386 := testStanza("Package", "app", "Architecture", "i386", "Depends", "libfoo")
+	libfoo := testStanza("Package", "libfoo", "Architecture", "amd64", "Pre-Depends", "libc6")
+	libc := testStanza("Package", "libc6", "Architecture", "amd64")
+	exim := testStanza("Package", "exim4", "Architecture", "amd64", "Provides", "mail-transport-agent")
+	postfix := testStanza("Package", "postfix", "Architecture", "amd64")
+	doc := testStanza("Package", "app-doc", "Architecture", "all")
+	other := testStanza("Package", "other", "Architecture", "amd64")
+
+	srcApp := testStanza("Package", "app-src")
+	srcOther := testStanza("Package", "other")
+
+	groups := []*indexGroup{
+		{
+			path:    "dists/a/main/binary-amd64/Packages",
+			stanzas: []*stanza{app, appI386, libfoo, libc, exim, postfix, other},
+		},
+		{
+			path:    "dists/b/main/binary-all/Packages",
+			stanzas: []*stanza{doc},
+		},
+		{
+			path:    "dists/a/main/source/Sources",
+			source:  true,
+			stanzas: []*stanza{srcApp, srcOther},
+		},
+	}
+
+	m := &Mirror{
+		id: "test",
+		mc: &MirrConfig{
+			Architectures: []string{"amd64"},
+			Packages:      []string{"app", "missing"},
+		},
+	}
+	m.resolveClosure(groups)
+
+	for _, s := range []*stanza{app, libfoo, libc, exim, srcApp} {
+		if !s.keep {
+			t.Error(`must be kept:`, s.d["Package"][0])
+		}
+	}
+	for _, s := range []*stanza{appI386, postfix, doc, other, srcOther} {
+		if s.keep {
+			t.Error(`must not be kept:`, s.d["Package"][0], s.d["Architecture"])
+		}
+	}
+
+	// with recommends
+	for _, g := range groups {
+		for _, s := range g.stanzas {
+			s.keep = true
+		}
+	}
+	m.mc.Recommends = true
+	m.resolveClosure(groups)
+	if !doc.keep {
+		t.Error(`recommended packages must be kept`)
+	}
+}
+
+func TestResolveClosureProviders(t *testing.T) {
+	t.Parallel()
+
+	app := testStanza("Package", "app", "Architecture", "amd64",
+		"Depends", "www-browser, postfix, mail-transport-agent, x-terminal-emulator")
+	browser := testStanza("Package", "www-browser", "Architecture", "amd64")
+	firefox := testStanza("Package", "firefox", "Architecture", "amd64", "Provides", "www-browser")
+	exim := testStanza("Package", "exim4", "Architecture", "amd64", "Provides", "mail-transport-agent")
+	postfix := testStanza("Package", "postfix", "Architecture", "amd64", "Provides", "mail-transport-agent")
+	xterm := testStanza("Package", "xterm", "Architecture", "amd64", "Provides", "x-terminal-emulator")
+	rxvt := testStanza("Package", "rxvt", "Architecture", "amd64", "Provides", "x-terminal-emulator")
+
+	groups := []*indexGroup{
+		{
+			path:    "dists/a/main/binary-amd64/Packages",
+			stanzas: []*stanza{app, browser, firefox, exim, postfix, xterm, rxvt},
+		},
+	}
+
+	m := &Mirror{
+		id: "test",
+		mc: &MirrConfig{
+			Architectures: []string{"amd64"},
+			Packages:      []string{"app"},
+		},
+	}
+	m.resolveClosure(groups)
+
+	// a real package, a provider already selected, and the first provider.
+	for _, s := range []*stanza{app, browser, postfix, xterm} {
+		if !s.keep {
+			t.Error(`must be kept:`, s.d["Package"][0])
+		}
+	}
+	for _, s := range []*stanza{firefox, exim, rxvt} {
+		if s.keep {
+			t.Error(`must not be kept:`, s.d["Package"][0])
+		}
+	}
+}
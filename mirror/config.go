@@ -4,8 +4,10 @@ import (
 	"errors"
 	"net/url"
 	"path"
+	"regexp"
 	"strings"
 
+	"github.com/cybozu-go/aptutil/apt"
 	"github.com/cybozu-go/cmd"
 )
 
@@ -13,6 +15,12 @@ const (
 	defaultMaxConns = 10
 )
 
+var (
+	// languagePattern matches language codes such as "ja", "pt_BR",
+	// or "ca@valencia".
+	languagePattern = regexp.MustCompile(`^[a-zA-Z0-9_@.-]+$`)
+)
+
 type tomlURL struct {
 	*url.URL
 }
@@ -39,13 +47,59 @@ func (u *tomlURL) UnmarshalText(text []byte) error {
 	return nil
 }
 
+// FilterConfig specifies packages to be mirrored.
+//
+// Patterns are glob patterns matched by path.Match, or regular
+// expressions if enclosed in slashes such as "/^lib.*-dev$/".
+//
+// A package is mirrored if its name matches any of Include (or Include
+// is empty) and none of Exclude, and if each of Sections, Priorities,
+// and Architectures is empty or has a pattern matching the field.
+// Architectures is not applied to source packages.
+type FilterConfig struct {
+	Include       []string `toml:"include"`
+	Exclude       []string `toml:"exclude"`
+	Sections      []string `toml:"sections"`
+	Priorities    []string `toml:"priorities"`
+	Architectures []string `toml:"architectures"`
+}
+
 // MirrConfig is an auxiliary struct for Config.
 type MirrConfig struct {
 	URL           tomlURL  `toml:"url"`
 	Suites        []string `toml:"suites"`
 	Sections      []string `toml:"sections"`
 	Source        bool     `toml:"mirror_source"`
+	Contents      bool     `toml:"mirror_contents"`
+	DEP11         bool     `toml:"mirror_dep11"`
+	CNF           bool     `toml:"mirror_cnf"`
+	Installer     bool     `toml:"mirror_installer"`
 	Architectures []string `toml:"architectures"`
+	Keyring       string   `toml:"keyring"`
+	MinChecksum   string   `toml:"min_checksum"`
+
+	// Packages lists root packages for dependency-closure mirroring.
+	// If not empty, only these packages and their dependencies
+	// are mirrored.  Recommends are followed if Recommends is true.
+	Packages   []string `toml:"packages"`
+	Recommends bool     `toml:"recommends"`
+
+	// KeepVersions limits the number of versions of each package
+	// and architecture to be mirrored.  Zero means no limit.
+	KeepVersions int `toml:"keep_versions"`
+
+	// SigningKey is an OpenPGP secret key file to sign Release
+	// regenerated for rewritten indices.  If empty, such Release
+	// is not signed, which is refused for mirrors with Keyring
+	// unless AllowUnsigned is true.
+	SigningKey    string `toml:"signing_key"`
+	AllowUnsigned bool   `toml:"allow_unsigned"`
+
+	// Languages limits Translation indices to be mirrored.
+	// "en" is always mirrored.  Empty means all languages.
+	Languages []string `toml:"languages"`
+
+	Filter *FilterConfig `toml:"filter"`
 }
 
 // isFlat returns true if suite ends with "/" as described in
@@ -73,6 +127,37 @@ func (mc *MirrConfig) Check() error {
 		}
 	}
 
+	if _, err := apt.ParseChecksumType(mc.MinChecksum); err != nil {
+		return err
+	}
+
+	for _, pkg := range mc.Packages {
+		if len(pkg) == 0 || strings.ContainsAny(pkg, " \t,|") {
+			return errors.New("invalid package name: " + pkg)
+		}
+	}
+	if mc.Recommends && len(mc.Packages) == 0 {
+		return errors.New("recommends requires packages")
+	}
+	if mc.KeepVersions < 0 {
+		return errors.New("negative keep_versions")
+	}
+	if len(mc.Keyring) > 0 && mc.selectsPackages() &&
+		len(mc.SigningKey) == 0 && !mc.AllowUnsigned {
+		return errors.New("keyring with filter, packages, or keep_versions requires signing_key or allow_unsigned")
+	}
+	for _, lang := range mc.Languages {
+		if !languagePattern.MatchString(lang) {
+			return errors.New("invalid language: " + lang)
+		}
+	}
+
+	if mc.Filter != nil {
+		if _, err := newPackageFilter(mc.Filter); err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
 
@@ -96,6 +181,12 @@ func (mc *MirrConfig) ReleaseFiles(suite string) []string {
 	return l
 }
 
+// selectsPackages returns true if mc mirrors only a part of packages
+// in Packages or Sources.
+func (mc *MirrConfig) selectsPackages() bool {
+	return mc.Filter != nil || len(mc.Packages) > 0 || mc.KeepVersions > 0
+}
+
 // Resolve returns *url.URL for a relative path.
 func (mc *MirrConfig) Resolve(p string) *url.URL {
 	return mc.URL.ResolveReference(&url.URL{Path: p})
@@ -107,6 +198,193 @@ func rawName(p string) string {
 	return base[0 : len(base)-len(ext)]
 }
 
+func isContents(rn string) bool {
+	return strings.HasPrefix(rn, "Contents-")
+}
+
+func contains(l []string, s string) bool {
+	for _, s2 := range l {
+		if s2 == s {
+			return true
+		}
+	}
+	return false
+}
+
+// matchingContents returns true if the given Contents index such as
+// "dists/stretch/main/Contents-amd64.gz" is for configured
+// architectures and sections.
+func (mc *MirrConfig) matchingContents(p string) bool {
+	arch := strings.TrimPrefix(rawName(p), "Contents-")
+	arch = strings.TrimPrefix(arch, "udeb-")
+	if isFlat(mc.Suites[0]) {
+		return arch != "source" || mc.Source
+	}
+
+	switch {
+	case arch == "source":
+		if !mc.Source {
+			return false
+		}
+	case !mc.matchingArch(arch):
+		return false
+	}
+
+	// Contents are placed in suite or component directories.
+	dir := path.Dir(p)
+	for _, suite := range mc.Suites {
+		if dir == path.Join("dists", suite) {
+			return true
+		}
+	}
+	return mc.matchingComponent(dir)
+}
+
+// matchingArch returns true if arch is "all" or one of the
+// configured architectures.
+func (mc *MirrConfig) matchingArch(arch string) bool {
+	return arch == "all" || contains(mc.Architectures, arch)
+}
+
+// matchingComponent returns true if dir is a component directory
+// of configured suites and sections such as "dists/stretch/main".
+func (mc *MirrConfig) matchingComponent(dir string) bool {
+	for _, suite := range mc.Suites {
+		sdir := path.Join("dists", suite)
+		for _, section := range mc.Sections {
+			component := strings.SplitN(path.Clean(section), "/", 2)[0]
+			if dir == path.Join(sdir, component) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// isDEP11 returns true if p is an AppStream metadata file such as
+// "dists/xenial/main/dep11/Components-amd64.yml.gz".
+func isDEP11(p string) bool {
+	return path.Base(path.Dir(p)) == "dep11"
+}
+
+// isCNF returns true if p is a command-not-found index such as
+// "dists/xenial/main/cnf/Commands-amd64.xz".
+func isCNF(p string) bool {
+	return path.Base(path.Dir(p)) == "cnf"
+}
+
+// trimArch returns the architecture in a file name such as
+// "Components-amd64.yml.gz" with the given prefix, or
+// an empty string if name does not start with prefix.
+func trimArch(name, prefix string) string {
+	if !strings.HasPrefix(name, prefix) {
+		return ""
+	}
+	return strings.SplitN(name[len(prefix):], ".", 2)[0]
+}
+
+// matchingDEP11 returns true if the given AppStream metadata file is
+// for configured architectures and sections.
+//
+// Components and CID-Index files are per architecture, and icons
+// are shared by all architectures.
+func (mc *MirrConfig) matchingDEP11(p string) bool {
+	if isFlat(mc.Suites[0]) {
+		return true
+	}
+
+	name := path.Base(p)
+	for _, prefix := range []string{"Components-", "CID-Index-"} {
+		if arch := trimArch(name, prefix); len(arch) > 0 && !mc.matchingArch(arch) {
+			return false
+		}
+	}
+	return mc.matchingComponent(path.Dir(path.Dir(p)))
+}
+
+// matchingCNF returns true if the given command-not-found index is
+// for configured architectures and sections.
+func (mc *MirrConfig) matchingCNF(p string) bool {
+	if isFlat(mc.Suites[0]) {
+		return true
+	}
+
+	if arch := trimArch(path.Base(p), "Commands-"); len(arch) > 0 && !mc.matchingArch(arch) {
+		return false
+	}
+	return mc.matchingComponent(path.Dir(path.Dir(p)))
+}
+
+func isTranslation(rn string) bool {
+	return strings.HasPrefix(rn, "Translation-")
+}
+
+// matchingTranslation returns true if mc is configured for the given
+// Translation index such as "dists/stretch/main/i18n/Translation-ja.bz2".
+func (mc *MirrConfig) matchingTranslation(p string) bool {
+	if len(mc.Languages) == 0 {
+		return true
+	}
+	lang := strings.TrimPrefix(rawName(p), "Translation-")
+	return lang == "en" || contains(mc.Languages, lang)
+}
+
+// isInstaller returns true if p is a file of debian-installer images
+// such as "dists/stretch/main/installer-amd64/current/images/SHA256SUMS".
+func isInstaller(p string) bool {
+	return strings.Contains("/"+p, "/installer-")
+}
+
+// matchingInstaller returns true if mc is configured for the given
+// checksum list of debian-installer images.
+//
+// Only images listed in SHA256SUMS of the current images are mirrored.
+func (mc *MirrConfig) matchingInstaller(p string) bool {
+	if !mc.Installer || isFlat(mc.Suites[0]) {
+		return false
+	}
+	if path.Base(p) != "SHA256SUMS" {
+		return false
+	}
+
+	// dists/SUITE/COMPONENT/installer-ARCH/current/...
+	for _, suite := range mc.Suites {
+		sdir := path.Join("dists", suite)
+		if !strings.HasPrefix(p, sdir+"/") {
+			continue
+		}
+		t := strings.Split(p[len(sdir)+1:], "/")
+		if len(t) < 4 || !strings.HasPrefix(t[1], "installer-") || t[2] != "current" {
+			continue
+		}
+		if mc.matchingArch(strings.TrimPrefix(t[1], "installer-")) &&
+			mc.matchingComponent(path.Join(sdir, t[0])) {
+			return true
+		}
+	}
+	return false
+}
+
+// needsIndex returns false if p is an auxiliary index listed in Release
+// that is not configured to be mirrored.
+//
+// Release is not rewritten just to skip them, because apt downloads
+// only indices that it is configured to use.
+func (mc *MirrConfig) needsIndex(p string) bool {
+	rn := rawName(p)
+	switch {
+	case isContents(rn):
+		return mc.Contents && mc.matchingContents(p)
+	case isTranslation(rn):
+		return mc.matchingTranslation(p)
+	case isDEP11(p):
+		return mc.DEP11 && mc.matchingDEP11(p)
+	case isCNF(p):
+		return mc.CNF && mc.matchingCNF(p)
+	}
+	return true
+}
+
 // MatchingIndex returns true if mc is configured for the given index.
 func (mc *MirrConfig) MatchingIndex(p string) bool {
 	rn := rawName(p)
@@ -44,6 +44,9 @@ func TestConfig(t *testing.T) {
 		if !ubuntu.Source {
 			t.Error(`!ubuntu.Source`)
 		}
+		if !ubuntu.Contents {
+			t.Error(`!ubuntu.Contents`)
+		}
 		if !reflect.DeepEqual(ubuntu.Architectures, []string{"amd64", "i386"}) {
 			t.Error(`!reflect.DeepEqual(ubuntu.Architectures)`)
 		}
@@ -80,6 +83,31 @@ func TestConfig(t *testing.T) {
 			"main", "restricted", "universe"}) {
 			t.Error(`!reflect.DeepEqual(security.Sections)`)
 		}
+		if security.Keyring != "/usr/share/keyrings/ubuntu-archive-keyring.gpg" {
+			t.Error(`security.Keyring != "/usr/share/keyrings/ubuntu-archive-keyring.gpg"`)
+		}
+		if security.MinChecksum != "sha256" {
+			t.Error(`security.MinChecksum != "sha256"`)
+		}
+		if security.SigningKey != "/etc/go-apt-mirror/signing.asc" {
+			t.Error(`security.SigningKey != "/etc/go-apt-mirror/signing.asc"`)
+		}
+		if security.Filter == nil || !reflect.DeepEqual(security.Filter.Exclude, []string{"*-dbg"}) {
+			t.Error(`!reflect.DeepEqual(security.Filter.Exclude)`)
+		}
+		if err := security.Check(); err != nil {
+			t.Error(err)
+		}
+
+		// rewriting indices of a signed repository must be explicit.
+		security.SigningKey = ""
+		if err := security.Check(); err == nil {
+			t.Error(`keyring and filter without signing_key must be refused`)
+		}
+		security.AllowUnsigned = true
+		if err := security.Check(); err != nil {
+			t.Error(err)
+		}
 	}
 }
 
@@ -145,6 +173,80 @@ func TestMirrorConfig(t *testing.T) {
 		t.Error(`!mc.MatchingIndex("trusty/main/debian-installer/source/Sources.xz")`)
 	}
 
+	if !mc.DEP11 || !mc.CNF {
+		t.Error(`!mc.DEP11 || !mc.CNF`)
+	}
+	for _, p := range []string{
+		"dists/trusty/main/dep11/Components-amd64.yml.gz",
+		"dists/trusty-updates/universe/dep11/Components-i386.yml.xz",
+		"dists/trusty/main/dep11/CID-Index-amd64.json.gz",
+		"dists/trusty/restricted/dep11/icons-64x64@2.tar.gz",
+		"dists/trusty/main/cnf/Commands-amd64.xz",
+		"dists/trusty-updates/universe/cnf/Commands-i386",
+	} {
+		if !mc.needsIndex(p) {
+			t.Error(`must be mirrored:`, p)
+		}
+		if mc.MatchingIndex(p) {
+			t.Error(`mc.MatchingIndex(p)`, p)
+		}
+	}
+	for _, p := range []string{
+		"dists/trusty/main/dep11/Components-arm64.yml.gz",
+		"dists/trusty/multiverse/dep11/Components-amd64.yml.gz",
+		"dists/trusty/multiverse/dep11/icons-64x64.tar.gz",
+		"dists/trusty-backports/main/dep11/icons-64x64.tar.gz",
+		"dists/trusty/main/cnf/Commands-arm64.xz",
+		"dists/trusty/multiverse/cnf/Commands-amd64.xz",
+	} {
+		if mc.needsIndex(p) {
+			t.Error(`must not be mirrored:`, p)
+		}
+	}
+	if !mc.Installer {
+		t.Error(`!mc.Installer`)
+	}
+	for _, p := range []string{
+		"dists/trusty/main/installer-amd64/current/images/SHA256SUMS",
+		"dists/trusty-updates/main/installer-i386/current/images/SHA256SUMS",
+	} {
+		if !mc.matchingInstaller(p) {
+			t.Error(`images must be mirrored:`, p)
+		}
+	}
+	for _, p := range []string{
+		"dists/trusty/main/installer-amd64/current/images/MD5SUMS",
+		"dists/trusty/main/installer-amd64/20101020ubuntu318/images/SHA256SUMS",
+		"dists/trusty/main/installer-arm64/current/images/SHA256SUMS",
+		"dists/trusty/multiverse/installer-amd64/current/images/SHA256SUMS",
+		"dists/trusty-backports/main/installer-amd64/current/images/SHA256SUMS",
+	} {
+		if mc.matchingInstaller(p) {
+			t.Error(`images must not be mirrored:`, p)
+		}
+		// checksum lists listed in Release are mirrored as they are.
+		if !mc.needsIndex(p) {
+			t.Error(`must be mirrored:`, p)
+		}
+	}
+	if !mc.needsIndex("dists/trusty/main/debian-installer/binary-amd64/Packages.gz") {
+		t.Error(`debian-installer Packages must be mirrored`)
+	}
+
+	noAux := *mc
+	noAux.DEP11 = false
+	noAux.CNF = false
+	noAux.Installer = false
+	if noAux.matchingInstaller("dists/trusty/main/installer-amd64/current/images/SHA256SUMS") {
+		t.Error(`installer images must not be mirrored unless mirror_installer is true`)
+	}
+	if noAux.needsIndex("dists/trusty/main/dep11/Components-amd64.yml.gz") {
+		t.Error(`DEP-11 must not be mirrored unless mirror_dep11 is true`)
+	}
+	if noAux.needsIndex("dists/trusty/main/cnf/Commands-amd64.xz") {
+		t.Error(`cnf must not be mirrored unless mirror_cnf is true`)
+	}
+
 	mc, ok = c.Mirrors["security"]
 	if !ok {
 		t.Fatal(`c.Mirrors["security"] not ok`)
@@ -159,6 +261,66 @@ func TestMirrorConfig(t *testing.T) {
 		t.Error(`mc.MatchingIndex("trusty-security/main/source/Sources.xz")`)
 	}
 
+	if mc.MatchingIndex("dists/trusty-security/Contents-amd64.gz") {
+		t.Error(`mc.MatchingIndex("dists/trusty-security/Contents-amd64.gz")`)
+	}
+	if mc.needsIndex("dists/trusty-security/Contents-amd64.gz") {
+		t.Error(`Contents must not be mirrored unless mirror_contents is true`)
+	}
+	if !mc.needsIndex("dists/trusty-security/main/binary-amd64/Packages.gz") {
+		t.Error(`!mc.needsIndex("dists/trusty-security/main/binary-amd64/Packages.gz")`)
+	}
+	if !reflect.DeepEqual(mc.Languages, []string{"ja", "pt_BR"}) {
+		t.Error(`!reflect.DeepEqual(mc.Languages, []string{"ja", "pt_BR"})`)
+	}
+	for _, p := range []string{
+		"dists/trusty-security/main/i18n/Translation-en.bz2",
+		"dists/trusty-security/main/i18n/Translation-ja.bz2",
+		"dists/trusty-security/main/i18n/Translation-pt_BR",
+	} {
+		if !mc.needsIndex(p) {
+			t.Error(`Translation must be mirrored:`, p)
+		}
+	}
+	for _, p := range []string{
+		"dists/trusty-security/main/i18n/Translation-de.bz2",
+		"dists/trusty-security/main/i18n/Translation-pt.bz2",
+	} {
+		if mc.needsIndex(p) {
+			t.Error(`Translation must not be mirrored:`, p)
+		}
+	}
+	badLang := *mc
+	badLang.Languages = []string{"ja/../en"}
+	if err := badLang.Check(); err == nil {
+		t.Error(`invalid language must be rejected`)
+	}
+
+	mc.Contents = true
+	for _, p := range []string{
+		"dists/trusty-security/Contents-amd64.gz",
+		"dists/trusty-security/main/Contents-udeb-amd64.gz",
+		"dists/trusty-security/universe/Contents-all",
+	} {
+		if !mc.needsIndex(p) {
+			t.Error(`Contents must be mirrored:`, p)
+		}
+		// Contents do not list items.
+		if mc.MatchingIndex(p) {
+			t.Error(`mc.MatchingIndex(p)`, p)
+		}
+	}
+	for _, p := range []string{
+		"dists/trusty-security/Contents-i386.gz",
+		"dists/trusty-security/multiverse/Contents-amd64.gz",
+		"dists/trusty-security/Contents-source.gz",
+		"dists/trusty/Contents-amd64.gz",
+	} {
+		if mc.needsIndex(p) {
+			t.Error(`Contents must not be mirrored:`, p)
+		}
+	}
+
 	mc, ok = c.Mirrors["flat"]
 	if !ok {
 		t.Fatal(`c.Mirrors["flat"] not ok`)
@@ -166,6 +328,26 @@ func TestMirrorConfig(t *testing.T) {
 	if err := mc.Check(); err != nil {
 		t.Error(err)
 	}
+	if !reflect.DeepEqual(mc.Packages, []string{"cybozu-tools"}) || !mc.Recommends {
+		t.Error(`!reflect.DeepEqual(mc.Packages, []string{"cybozu-tools"}) || !mc.Recommends`)
+	}
+	if mc.KeepVersions != 3 {
+		t.Error(`mc.KeepVersions != 3`)
+	}
+	badmc := *mc
+	badmc.Packages = []string{"foo, bar"}
+	if err := badmc.Check(); err == nil {
+		t.Error(`invalid package name must be rejected`)
+	}
+	badmc.Packages = nil
+	if err := badmc.Check(); err == nil {
+		t.Error(`recommends without packages must be rejected`)
+	}
+	badmc.Recommends = false
+	badmc.KeepVersions = -1
+	if err := badmc.Check(); err == nil {
+		t.Error(`negative keep_versions must be rejected`)
+	}
 
 	m = make(map[string]struct{})
 	for _, p := range mc.ReleaseFiles("12.04/") {
@@ -0,0 +1,144 @@
+package mirror
+
+// This file implements searching Contents indices in mirrors,
+// that is like apt-file but works offline.
+
+import (
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+
+	"github.com/cybozu-go/aptutil/apt"
+	"github.com/pkg/errors"
+)
+
+// ContentsResult is a file found by SearchContents.
+type ContentsResult struct {
+	// Path is the absolute path of the file such as "/usr/bin/curl".
+	Path string
+
+	// Packages is a list of names of packages shipping the file.
+	Packages []string
+
+	// Index is the path of the Contents index relative to the mirror.
+	Index string
+}
+
+// findContents returns paths of Contents indices in a mirror
+// directory, relative to the directory.
+//
+// If an index has several compression variants, one of them is chosen.
+func findContents(root string, mc *MirrConfig) ([]string, error) {
+	variants := make(map[string]string)
+	err := filepath.Walk(root, func(fp string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			if info.Name() == "by-hash" {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		rel, err := filepath.Rel(root, fp)
+		if err != nil {
+			return err
+		}
+		p := filepath.ToSlash(rel)
+		rn := rawName(p)
+		if !isContents(rn) || !apt.IsSupported(p) || !mc.matchingContents(p) {
+			return nil
+		}
+
+		key := path.Join(path.Dir(p), rn)
+		if cur, ok := variants[key]; !ok || p < cur {
+			variants[key] = p
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	l := make([]string, 0, len(variants))
+	for _, p := range variants {
+		l = append(l, p)
+	}
+	sort.Strings(l)
+	return l, nil
+}
+
+func searchContentsIndex(root, p string, match func(string) bool) ([]*ContentsResult, error) {
+	f, err := os.Open(filepath.Join(root, filepath.FromSlash(p)))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	r, err := apt.Decompress(p, f)
+	if err != nil {
+		return nil, errors.Wrap(err, p)
+	}
+	defer r.Close()
+
+	var results []*ContentsResult
+	parser := apt.NewContentsParser(r)
+	for {
+		e, err := parser.Read()
+		if err == io.EOF {
+			return results, nil
+		}
+		if err != nil {
+			return nil, errors.Wrap(err, p)
+		}
+
+		fpath := "/" + e.Path
+		if !match(fpath) {
+			continue
+		}
+		results = append(results, &ContentsResult{
+			Path:     fpath,
+			Packages: e.PackageNames(),
+			Index:    p,
+		})
+	}
+}
+
+// SearchContents searches Contents indices in the current mirror
+// of id for files whose absolute path satisfies match.
+//
+// Contents indices are mirrored only if mirror_contents is true.
+// Results are ordered by Contents indices, then by lines in them.
+func SearchContents(c *Config, id string, match func(p string) bool) ([]*ContentsResult, error) {
+	mc, ok := c.Mirrors[id]
+	if !ok {
+		return nil, errors.New("no such mirror: " + id)
+	}
+	if !mc.Contents {
+		return nil, errors.New(id + ": mirror_contents is not enabled")
+	}
+
+	// resolve the symlink once to search a consistent snapshot.
+	root, err := filepath.EvalSymlinks(filepath.Join(filepath.Clean(c.Dir), id))
+	if err != nil {
+		return nil, errors.Wrap(err, id)
+	}
+
+	indices, err := findContents(root, mc)
+	if err != nil {
+		return nil, errors.Wrap(err, id)
+	}
+
+	var results []*ContentsResult
+	for _, p := range indices {
+		rl, err := searchContentsIndex(root, p, match)
+		if err != nil {
+			return nil, errors.Wrap(err, id)
+		}
+		results = append(results, rl...)
+	}
+	return results, nil
+}
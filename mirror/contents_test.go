@@ -0,0 +1,85 @@
+package mirror
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func testWriteFile(t *testing.T, p string, data []byte) {
+	if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(p, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSearchContents(t *testing.T) {
+	t.Parallel()
+
+	d, err := ioutil.TempDir("", "gotest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(d)
+
+	const contents = "usr/bin/curl    web/curl\nusr/bin/wget    web/wget\nusr/share/doc/curl/README    web/curl,doc/curl-doc\n"
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	gz.Write([]byte(contents))
+	gz.Close()
+
+	root := filepath.Join(d, ".ubuntu.20170101_000000", "ubuntu")
+	testWriteFile(t, filepath.Join(root, "dists/trusty/Contents-amd64.gz"), buf.Bytes())
+	testWriteFile(t, filepath.Join(root, "dists/trusty/Contents-amd64"), []byte(contents))
+	testWriteFile(t, filepath.Join(root, "dists/trusty/Contents-i386.gz"), buf.Bytes())
+	testWriteFile(t, filepath.Join(root, "dists/trusty/by-hash/MD5Sum/0123"), []byte("bad"))
+	testWriteFile(t, filepath.Join(root, "dists/trusty-updates/main/Contents-amd64.gz"), buf.Bytes())
+	if err := os.Symlink(root, filepath.Join(d, "ubuntu")); err != nil {
+		t.Fatal(err)
+	}
+
+	c := NewConfig()
+	c.Dir = d
+	c.Mirrors = map[string]*MirrConfig{
+		"ubuntu": {
+			Suites:        []string{"trusty", "trusty-updates"},
+			Sections:      []string{"main"},
+			Architectures: []string{"amd64"},
+			Contents:      true,
+		},
+	}
+
+	results, err := SearchContents(c, "ubuntu", func(p string) bool {
+		return strings.Contains(p, "curl")
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 4 {
+		t.Fatal(`len(results) != 4`, len(results))
+	}
+	if results[0].Path != "/usr/bin/curl" || results[0].Index != "dists/trusty-updates/main/Contents-amd64.gz" {
+		t.Error(`unexpected result`, results[0])
+	}
+	if results[3].Index != "dists/trusty/Contents-amd64" {
+		t.Error(`uncompressed index should be chosen`, results[3].Index)
+	}
+	if !reflect.DeepEqual(results[3].Packages, []string{"curl", "curl-doc"}) {
+		t.Error(`unexpected packages`, results[3].Packages)
+	}
+
+	if _, err := SearchContents(c, "debian", func(string) bool { return true }); err == nil {
+		t.Error(`unknown mirror must be an error`)
+	}
+	c.Mirrors["ubuntu"].Contents = false
+	if _, err := SearchContents(c, "ubuntu", func(string) bool { return true }); err == nil {
+		t.Error(`mirror without contents must be an error`)
+	}
+}
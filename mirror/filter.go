@@ -0,0 +1,124 @@
+package mirror
+
+// This file implements package filters to mirror a part of a repository.
+
+import (
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/cybozu-go/aptutil/apt"
+	"github.com/pkg/errors"
+)
+
+type matcher func(s string) bool
+
+func compilePattern(pattern string) (matcher, error) {
+	if len(pattern) > 1 && strings.HasPrefix(pattern, "/") && strings.HasSuffix(pattern, "/") {
+		re, err := regexp.Compile(pattern[1 : len(pattern)-1])
+		if err != nil {
+			return nil, errors.Wrap(err, "bad pattern: "+pattern)
+		}
+		return re.MatchString, nil
+	}
+
+	if _, err := path.Match(pattern, ""); err != nil {
+		return nil, errors.Wrap(err, "bad pattern: "+pattern)
+	}
+	return func(s string) bool {
+		ok, _ := path.Match(pattern, s)
+		return ok
+	}, nil
+}
+
+func compilePatterns(patterns []string) ([]matcher, error) {
+	ml := make([]matcher, 0, len(patterns))
+	for _, pattern := range patterns {
+		m, err := compilePattern(pattern)
+		if err != nil {
+			return nil, err
+		}
+		ml = append(ml, m)
+	}
+	return ml, nil
+}
+
+func matchAny(ml []matcher, s string) bool {
+	for _, m := range ml {
+		if m(s) {
+			return true
+		}
+	}
+	return false
+}
+
+// packageFilter is a compiled FilterConfig.
+type packageFilter struct {
+	include       []matcher
+	exclude       []matcher
+	sections      []matcher
+	priorities    []matcher
+	architectures []matcher
+}
+
+func newPackageFilter(fc *FilterConfig) (*packageFilter, error) {
+	f := new(packageFilter)
+	for _, t := range []struct {
+		ml       *[]matcher
+		patterns []string
+	}{
+		{&f.include, fc.Include},
+		{&f.exclude, fc.Exclude},
+		{&f.sections, fc.Sections},
+		{&f.priorities, fc.Priorities},
+		{&f.architectures, fc.Architectures},
+	} {
+		ml, err := compilePatterns(t.patterns)
+		if err != nil {
+			return nil, err
+		}
+		*t.ml = ml
+	}
+	return f, nil
+}
+
+// matchField returns true if the field of d matches any of ml.
+// Empty ml matches anything.
+func matchField(ml []matcher, d apt.Paragraph, field string) bool {
+	if len(ml) == 0 {
+		return true
+	}
+	v, ok := d.Get(field)
+	if !ok {
+		return false
+	}
+	return matchAny(ml, v[0])
+}
+
+// keep returns true if a package described by d should be mirrored.
+//
+// source must be true if d is a paragraph of Sources.
+func (f *packageFilter) keep(d apt.Paragraph, source bool) bool {
+	pkg, ok := d.Get("Package")
+	if !ok {
+		return false
+	}
+	name := pkg[0]
+
+	if len(f.include) > 0 && !matchAny(f.include, name) {
+		return false
+	}
+	if matchAny(f.exclude, name) {
+		return false
+	}
+	if !matchField(f.sections, d, "Section") {
+		return false
+	}
+	if !matchField(f.priorities, d, "Priority") {
+		return false
+	}
+	if !source && !matchField(f.architectures, d, "Architecture") {
+		return false
+	}
+	return true
+}
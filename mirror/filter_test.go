@@ -0,0 +1,58 @@
+package mirror
+
+import (
+	"testing"
+
+	"github.com/cybozu-go/aptutil/apt"
+)
+
+func TestPackageFilter(t *testing.T) {
+	t.Parallel()
+
+	if _, err := newPackageFilter(&FilterConfig{Include: []string{"/[/"}}); err == nil {
+		t.Error(`bad regexp must be rejected`)
+	}
+	if _, err := newPackageFilter(&FilterConfig{Exclude: []string{"[a"}}); err == nil {
+		t.Error(`bad glob must be rejected`)
+	}
+
+	f, err := newPackageFilter(&FilterConfig{
+		Include:       []string{"lib*", "/^python3-/"},
+		Exclude:       []string{"*-dbg"},
+		Sections:      []string{"libs", "python"},
+		Architectures: []string{"amd64", "all"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := []struct {
+		d      apt.Paragraph
+		source bool
+		keep   bool
+	}{
+		{apt.Paragraph{"Package": {"libc6"}, "Section": {"libs"}, "Architecture": {"amd64"}}, false, true},
+		{apt.Paragraph{"Package": {"python3-yaml"}, "Section": {"python"}, "Architecture": {"all"}}, false, true},
+		{apt.Paragraph{"Package": {"libc6-dbg"}, "Section": {"libs"}, "Architecture": {"amd64"}}, false, false},
+		{apt.Paragraph{"Package": {"bash"}, "Section": {"shells"}, "Architecture": {"amd64"}}, false, false},
+		{apt.Paragraph{"Package": {"libc6"}, "Section": {"debug"}, "Architecture": {"amd64"}}, false, false},
+		{apt.Paragraph{"Package": {"libc6"}, "Architecture": {"amd64"}}, false, false},
+		{apt.Paragraph{"Package": {"libc6"}, "Section": {"libs"}, "Architecture": {"i386"}}, false, false},
+		{apt.Paragraph{"Package": {"libc6"}, "Section": {"libs"}, "Architecture": {"any"}}, true, true},
+		{apt.Paragraph{"Section": {"libs"}}, false, false},
+	}
+	for _, tc := range testCases {
+		if f.keep(tc.d, tc.source) != tc.keep {
+			t.Error(`f.keep(tc.d, tc.source) != tc.keep`, tc.d)
+		}
+	}
+
+	// empty filter keeps everything
+	f, err = newPackageFilter(&FilterConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !f.keep(apt.Paragraph{"Package": {"bash"}}, false) {
+		t.Error(`empty filter must keep packages`)
+	}
+}
@@ -0,0 +1,48 @@
+package mirror
+
+// This file implements mirroring of debian-installer images.
+//
+// Images are not listed in Packages.  Instead, they are listed in
+// SHA256SUMS whose checksums are in Release.
+
+import (
+	"path"
+
+	"github.com/cybozu-go/aptutil/apt"
+	"github.com/pkg/errors"
+)
+
+// extractInstallerItems adds images listed in SHA256SUMS of
+// debian-installer to itemMap.
+func (m *Mirror) extractInstallerItems(indices []*apt.FileInfo, indexMap map[string][]*apt.FileInfo, itemMap map[string]*apt.FileInfo, byhash bool) error {
+	for _, index := range indices {
+		p := index.Path()
+		if !isInstaller(p) || !m.mc.matchingInstaller(p) {
+			continue
+		}
+		hashPath := p
+		if byhash {
+			hashPath = index.SHA256Path()
+		}
+		f, err := m.storage.Open(hashPath)
+		if err != nil {
+			return err
+		}
+
+		fil, err := apt.ParseChecksumList(path.Dir(p), apt.ChecksumSHA256, f)
+		f.Close()
+		if err != nil {
+			return errors.Wrap(err, p)
+		}
+
+		for _, fi := range fil {
+			fipath := fi.Path()
+			if _, ok := indexMap[fipath]; ok {
+				// already included in Release/InRelease
+				continue
+			}
+			itemMap[fipath] = fi
+		}
+	}
+	return nil
+}
@@ -0,0 +1,149 @@
+package mirror
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/cybozu-go/aptutil/apt"
+)
+
+func TestExtractInstallerItems(t *testing.T) {
+	t.Parallel()
+
+	d, err := ioutil.TempDir("", "gotest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(d)
+
+	storage, err := NewStorage(d, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const dir = "dists/stretch/main/installer-amd64/current/images"
+	image := apt.MakeFileInfo(dir+"/netboot/netboot.tar.gz", []byte("netboot"))
+	sums := []byte(hex.EncodeToString(image.Checksum(apt.ChecksumSHA256)) + "  ./netboot/netboot.tar.gz\n")
+
+	var indices []*apt.FileInfo
+	for _, p := range []string{
+		dir + "/SHA256SUMS",
+		"dists/stretch/main/installer-arm64/current/images/SHA256SUMS",
+	} {
+		fi := apt.MakeFileInfo(p, sums)
+		if err := storage.Store(fi, sums); err != nil {
+			t.Fatal(err)
+		}
+		indices = append(indices, fi)
+	}
+
+	m := &Mirror{
+		id: "test",
+		mc: &MirrConfig{
+			Suites:        []string{"stretch"},
+			Sections:      []string{"main"},
+			Architectures: []string{"amd64"},
+			Installer:     true,
+		},
+		storage: storage,
+	}
+
+	itemMap := make(map[string]*apt.FileInfo)
+	err = m.extractInstallerItems(indices, make(map[string][]*apt.FileInfo), itemMap, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(itemMap) != 1 {
+		t.Fatal(`len(itemMap) != 1`, len(itemMap))
+	}
+	fi, ok := itemMap[image.Path()]
+	if !ok {
+		t.Fatal(`image is not extracted`)
+	}
+	if !fi.Same(image) {
+		t.Error(`!fi.Same(image)`)
+	}
+	if fi.Same(apt.MakeFileInfo(image.Path(), []byte("broken"))) {
+		t.Error(`broken image must not match`)
+	}
+}
+
+func TestUpdateSignedInstaller(t *testing.T) {
+	t.Parallel()
+
+	signer, err := apt.LoadSigner("../apt/testdata/gpg/signing.asc")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const dir = "main/installer-amd64/current/images"
+	image := []byte("netboot")
+	sums := []byte(fmt.Sprintf("%x  ./netboot/netboot.tar.gz\n", sha256.Sum256(image)))
+	release := fmt.Sprintf("Origin: test\nSuite: stretch\nDate: %s\nSHA256:\n %x %d %s/SHA256SUMS\n",
+		time.Now().UTC().Format(time.RFC1123), sha256.Sum256(sums), len(sums), dir)
+	inRelease, err := signer.SignInRelease([]byte(release))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	files := map[string][]byte{
+		"/dists/stretch/InRelease":                          inRelease,
+		"/dists/stretch/" + dir + "/SHA256SUMS":             sums,
+		"/dists/stretch/" + dir + "/netboot/netboot.tar.gz": image,
+	}
+	m, cleanup := testMirror(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, ok := files[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write(data)
+	}))
+	defer cleanup()
+
+	d, err := ioutil.TempDir("", "gotest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(d)
+	m.dir = d
+	m.mc.Suites = []string{"stretch"}
+	m.mc.Sections = []string{"main"}
+	m.mc.Architectures = []string{"amd64"}
+	m.mc.Installer = true
+	m.keyring = signer.Keyring()
+	for i := 0; i < cap(m.semaphore); i++ {
+		m.semaphore <- struct{}{}
+	}
+
+	err = m.Update(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stored, err := readStoredFile(m.storage, "dists/stretch/InRelease")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(stored, inRelease) {
+		t.Error(`InRelease must be kept as it is`)
+	}
+	if _, err := m.storage.Open("dists/stretch/Release"); !os.IsNotExist(err) {
+		t.Error(`Release must not be regenerated`)
+	}
+	stored, err = readStoredFile(m.storage, "dists/stretch/"+dir+"/netboot/netboot.tar.gz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(stored, image) {
+		t.Error(`installer image must be mirrored`)
+	}
+}
@@ -10,6 +10,8 @@ import (
 	"path"
 	"path/filepath"
 	"regexp"
+	"sort"
+	"strings"
 	"time"
 
 	"github.com/cybozu-go/aptutil/apt"
@@ -35,6 +37,19 @@ type Mirror struct {
 	mc      *MirrConfig
 	storage *Storage
 	current *Storage
+	keyring *apt.Keyring
+
+	// signer signs Release regenerated for rewritten indices, or nil.
+	signer *apt.Signer
+
+	// incomplete is the storage left by an interrupted update, if any.
+	incomplete *Storage
+
+	// minimum type of checksums required for indices and items.
+	minChecksum apt.ChecksumType
+
+	// filter selects packages to be mirrored, or nil to mirror all.
+	filter *packageFilter
 
 	semaphore chan struct{}
 	client    *http.Client
@@ -57,13 +72,15 @@ func NewMirror(t time.Time, id string, c *Config) (*Mirror, error) {
 	}
 
 	var currentStorage *Storage
+	var currentDir string
 	curdir, err := filepath.EvalSymlinks(filepath.Join(dir, id))
 	switch {
 	case os.IsNotExist(err):
 	case err != nil:
 		return nil, errors.Wrap(err, id)
 	default:
-		currentStorage, err = NewStorage(filepath.Dir(curdir), id)
+		currentDir = filepath.Dir(curdir)
+		currentStorage, err = NewStorage(currentDir, id)
 		if err != nil {
 			return nil, errors.Wrap(err, id)
 		}
@@ -73,6 +90,53 @@ func NewMirror(t time.Time, id string, c *Config) (*Mirror, error) {
 		}
 	}
 
+	var incompleteStorage *Storage
+	incompleteDir, err := findIncomplete(dir, id, currentDir)
+	if err != nil {
+		return nil, errors.Wrap(err, id)
+	}
+	if len(incompleteDir) > 0 {
+		incompleteStorage, err = NewStorage(incompleteDir, id)
+		if err != nil {
+			return nil, errors.Wrap(err, id)
+		}
+		err = incompleteStorage.Scan()
+		if err != nil {
+			return nil, errors.Wrap(err, id)
+		}
+		log.Info("found incomplete mirror", map[string]interface{}{
+			"repo": id,
+			"dir":  incompleteDir,
+		})
+	}
+
+	var keyring *apt.Keyring
+	if len(mc.Keyring) > 0 {
+		keyring, err = apt.LoadKeyring(mc.Keyring)
+		if err != nil {
+			return nil, errors.Wrap(err, id)
+		}
+	}
+
+	var signer *apt.Signer
+	if len(mc.SigningKey) > 0 {
+		signer, err = apt.LoadSigner(mc.SigningKey)
+		if err != nil {
+			return nil, errors.Wrap(err, id)
+		}
+	}
+
+	// already validated by mc.Check
+	minChecksum, _ := apt.ParseChecksumType(mc.MinChecksum)
+
+	var filter *packageFilter
+	if mc.Filter != nil {
+		filter, err = newPackageFilter(mc.Filter)
+		if err != nil {
+			return nil, errors.Wrap(err, id)
+		}
+	}
+
 	d := filepath.Join(dir, "."+id+"."+t.Format(timestampFormat))
 	err = os.Mkdir(d, 0755)
 	if err != nil {
@@ -94,12 +158,17 @@ func NewMirror(t time.Time, id string, c *Config) (*Mirror, error) {
 	}
 
 	mr := &Mirror{
-		id:        id,
-		dir:       dir,
-		mc:        mc,
-		storage:   storage,
-		current:   currentStorage,
-		semaphore: sem,
+		id:          id,
+		dir:         dir,
+		mc:          mc,
+		storage:     storage,
+		current:     currentStorage,
+		keyring:     keyring,
+		signer:      signer,
+		incomplete:  incompleteStorage,
+		minChecksum: minChecksum,
+		filter:      filter,
+		semaphore:   sem,
 		client: &http.Client{
 			Transport: transport,
 		},
@@ -107,11 +176,38 @@ func NewMirror(t time.Time, id string, c *Config) (*Mirror, error) {
 	return mr, nil
 }
 
-func (m *Mirror) store(fi *apt.FileInfo, data []byte, byhash bool) error {
-	if byhash {
-		return m.storage.StoreWithHash(fi, data)
+// findIncomplete returns the newest mirror directory for id that is
+// left by an interrupted update, or an empty string if not found.
+//
+// current is the directory of the current mirror, which is excluded.
+func findIncomplete(dir, id, current string) (string, error) {
+	prefix := "." + id + "."
+	dirs, err := filepath.Glob(filepath.Join(dir, prefix+"*"))
+	if err != nil {
+		return "", err
 	}
-	return m.storage.Store(fi, data)
+
+	// timestamps in names sort in chronological order.
+	sort.Strings(dirs)
+	for i := len(dirs) - 1; i >= 0; i-- {
+		d := dirs[i]
+		if d == current {
+			continue
+		}
+		ts := strings.TrimPrefix(filepath.Base(d), prefix)
+		if _, err := time.Parse(timestampFormat, ts); err != nil {
+			continue
+		}
+		st, err := os.Stat(d)
+		if err != nil {
+			return "", err
+		}
+		if !st.IsDir() {
+			continue
+		}
+		return d, nil
+	}
+	return "", nil
 }
 
 func (m *Mirror) storeLink(fi *apt.FileInfo, fp string, byhash bool) error {
@@ -178,13 +274,36 @@ func (m *Mirror) replaceLink() error {
 
 // Update updates mirrored files.
 func (m *Mirror) Update(ctx context.Context) error {
-	itemMap := make(map[string]*apt.FileInfo)
-
+	var sl []*suiteIndices
 	for _, suite := range m.mc.Suites {
-		err := m.updateSuite(ctx, suite, itemMap)
+		si, err := m.updateSuite(ctx, suite)
 		if err != nil {
 			return err
 		}
+		sl = append(sl, si)
+	}
+
+	// rewrite indices to contain only selected packages
+	if m.mc.selectsPackages() {
+		err := m.rewriteIndices(sl)
+		if err != nil {
+			return errors.Wrap(err, m.id)
+		}
+	}
+
+	// extract file information from indices
+	itemMap := make(map[string]*apt.FileInfo)
+	for _, si := range sl {
+		err := m.extractItems(si.indices, si.indexMap, itemMap, si.byhash)
+		if err != nil {
+			return errors.Wrap(err, m.id)
+		}
+		if m.mc.Installer {
+			err = m.extractInstallerItems(si.indices, si.indexMap, itemMap, si.byhash)
+			if err != nil {
+				return errors.Wrap(err, m.id)
+			}
+		}
 	}
 
 	// download all files matching the configuration.
@@ -218,18 +337,52 @@ func (m *Mirror) Update(ctx context.Context) error {
 	return nil
 }
 
-// updateSuite partially updates mirror for a suite.
-func (m *Mirror) updateSuite(ctx context.Context, suite string, itemMap map[string]*apt.FileInfo) error {
+// suiteIndices is a set of indices downloaded for a suite.
+type suiteIndices struct {
+	suite    string
+	indexMap map[string][]*apt.FileInfo
+	indices  []*apt.FileInfo
+	byhash   bool
+
+	// release is the paragraph of InRelease or Release.
+	// For InRelease, this is read only from the signed text.
+	release *apt.OrderedParagraph
+}
+
+// setRelease sets the paragraph read from text of the Release file
+// at p, preferring InRelease to Release.
+func (si *suiteIndices) setRelease(p string, text []byte) error {
+	switch path.Base(p) {
+	case "InRelease":
+	case "Release":
+		if si.release != nil {
+			return nil
+		}
+	default:
+		return nil
+	}
+
+	op, err := apt.NewParser(bytes.NewReader(text)).ReadOrdered()
+	if err != nil {
+		return errors.Wrap(err, p)
+	}
+	si.release = op
+	return nil
+}
+
+// updateSuite downloads Release and indices for a suite.
+func (m *Mirror) updateSuite(ctx context.Context, suite string) (*suiteIndices, error) {
 	log.Info("download Release/InRelease", map[string]interface{}{
 		"repo":  m.id,
 		"suite": suite,
 	})
-	indexMap, byhash, err := m.downloadRelease(ctx, suite)
+	si, err := m.downloadRelease(ctx, suite)
 	if err != nil {
-		return errors.Wrap(err, m.id)
+		return nil, errors.Wrap(err, m.id)
 	}
+	indexMap := si.indexMap
 
-	if byhash {
+	if si.byhash {
 		log.Info("detected by-hash support", map[string]interface{}{
 			"repo":  m.id,
 			"suite": suite,
@@ -237,7 +390,7 @@ func (m *Mirror) updateSuite(ctx context.Context, suite string, itemMap map[stri
 	}
 
 	if len(indexMap) == 0 {
-		return errors.New(m.id + ": found no Release/InRelease")
+		return nil, errors.New(m.id + ": found no Release/InRelease")
 	}
 
 	// WORKAROUND: some (zabbix) repositories returns wrong contents
@@ -256,26 +409,36 @@ func (m *Mirror) updateSuite(ctx context.Context, suite string, itemMap map[stri
 		indexMap = tmpMap
 	}
 
-	// download (or reuse) all indices
-	indices, err := m.downloadIndices(ctx, indexMap, byhash)
-	if err != nil {
-		return errors.Wrap(err, m.id)
+	// skip auxiliary indices not configured to be mirrored
+	for p := range indexMap {
+		if !m.mc.needsIndex(p) {
+			delete(indexMap, p)
+		}
 	}
 
-	// extract file information from indices
-	err = m.extractItems(indices, indexMap, itemMap, byhash)
+	// download (or reuse) all indices
+	indices, err := m.downloadIndices(ctx, indexMap, si.byhash)
 	if err != nil {
-		return errors.Wrap(err, m.id)
+		return nil, errors.Wrap(err, m.id)
 	}
-	return nil
+
+	si.indexMap = indexMap
+	si.indices = indices
+	return si, nil
 }
 
 type dlResult struct {
 	status int
 	path   string
 	fi     *apt.FileInfo
-	data   []byte
-	err    error
+
+	// tmpfile is the temporary file that holds the downloaded data.
+	tmpfile string
+
+	// data is the downloaded data, set only for Release files.
+	data []byte
+
+	err error
 }
 
 // download is a goroutine to download an item.
@@ -285,14 +448,25 @@ func (m *Mirror) download(ctx context.Context,
 	r := &dlResult{
 		path: p,
 	}
+
+	// partially downloaded data kept across retries and updates.
+	pt := m.loadPartial(p)
 	defer func() {
+		if pt != nil {
+			m.keepPartial(p, pt)
+		}
 		ch <- r
 		m.semaphore <- struct{}{}
 	}()
 
+	// resumed is true if the current attempt continues the partial data.
 	var retries uint
+	var resumed bool
 	targets := []string{p}
 	if byhash && fi != nil {
+		if sha512p := fi.SHA512Path(); len(sha512p) > 0 {
+			targets = append(targets, sha512p)
+		}
 		targets = append(targets, fi.SHA256Path())
 		targets = append(targets, fi.SHA1Path())
 		targets = append(targets, fi.MD5SumPath())
@@ -323,6 +497,14 @@ RETRY:
 		ProtoMinor: 1,
 		Header:     make(http.Header),
 	}
+	if pt != nil {
+		if pt.resumable(fi) {
+			pt.setRange(req.Header)
+		} else {
+			pt.discard()
+			pt = nil
+		}
+	}
 	resp, err := m.client.Do(req.WithContext(ctx))
 	if err != nil {
 		if retries < httpRetries {
@@ -340,10 +522,62 @@ RETRY:
 		})
 	}
 
-	r.status = resp.StatusCode
-	data, err := ioutil.ReadAll(resp.Body)
+	switch {
+	case resp.StatusCode == http.StatusPartialContent && pt != nil && pt.accepts(resp):
+		resumed = true
+		log.Info("resume download", map[string]interface{}{
+			"repo":   m.id,
+			"path":   p,
+			"offset": pt.size,
+		})
+	case resp.StatusCode == http.StatusOK:
+		resumed = false
+		// the file has been changed, or the server ignored Range.
+		if pt != nil {
+			pt.discard()
+			pt = nil
+		}
+		pt, err = m.newPartial(p, resp)
+		if err != nil {
+			resp.Body.Close()
+			r.err = err
+			return
+		}
+	default:
+		resp.Body.Close()
+		if pt != nil {
+			// e.g. 416 Range Not Satisfiable; start over.
+			pt.discard()
+			pt = nil
+			if retries < httpRetries {
+				retries++
+				goto RETRY
+			}
+		}
+		r.status = resp.StatusCode
+		if r.status == http.StatusNotFound && targets[0] != p && len(targets) > 1 {
+			// by-hash directories may not exist for all checksum types.
+			targets = targets[1:]
+			goto RETRY
+		}
+		if r.status >= 500 && retries < httpRetries {
+			retries++
+			goto RETRY
+		}
+		return
+	}
+	r.status = http.StatusOK
+
+	start := pt.size
+	err = pt.fill(resp.Body)
 	resp.Body.Close()
 	if err != nil {
+		if resumed && pt.size > start {
+			// made progress; keep retrying as long as data arrive.
+			// restarts from the beginning are not counted as progress
+			// to avoid retrying forever.
+			retries = 0
+		}
 		if retries < httpRetries {
 			retries++
 			goto RETRY
@@ -351,16 +585,16 @@ RETRY:
 		r.err = err
 		return
 	}
-	if r.status >= 500 && retries < httpRetries {
-		retries++
-		goto RETRY
-	}
-	if r.status != 200 {
+
+	fi2, tmpfile, err := pt.finish()
+	pt = nil
+	if err != nil {
+		r.err = err
 		return
 	}
 
-	fi2 := apt.MakeFileInfo(p, data)
 	if fi != nil && !fi.Same(fi2) {
+		os.Remove(tmpfile)
 		if len(targets) > 1 {
 			targets = targets[1:]
 			log.Warn("try by-hash retrieval", map[string]interface{}{
@@ -374,7 +608,7 @@ RETRY:
 		return
 	}
 	r.fi = fi2
-	r.data = data
+	r.tmpfile = tmpfile
 }
 
 func addFileInfoToList(fi *apt.FileInfo, m map[string][]*apt.FileInfo, byhash bool) error {
@@ -399,26 +633,37 @@ func addFileInfoToList(fi *apt.FileInfo, m map[string][]*apt.FileInfo, byhash bo
 	return nil
 }
 
-func (m *Mirror) downloadRelease(ctx context.Context, suite string) (map[string][]*apt.FileInfo, bool, error) {
+func (m *Mirror) downloadRelease(ctx context.Context, suite string) (*suiteIndices, error) {
 	releases := m.mc.ReleaseFiles(suite)
 	results := make(chan *dlResult, len(releases))
 
+	launched, received := 0, 0
+	defer func() {
+		// remove temporary files of downloads not received due to an error.
+		for ; received < launched; received++ {
+			if r := <-results; len(r.tmpfile) > 0 {
+				os.Remove(r.tmpfile)
+			}
+		}
+	}()
+
 	for _, p := range releases {
 		select {
 		case <-ctx.Done():
-			return nil, false, ctx.Err()
+			return nil, ctx.Err()
 		case <-m.semaphore:
 		}
 
 		go m.download(ctx, p, nil, false, results)
+		launched++
 	}
 
-	byhash := true
-	filMap := make(map[string][]*apt.FileInfo)
-	for i := 0; i < len(releases); i++ {
+	var rl []*dlResult
+	for received < launched {
 		r := <-results
+		received++
 		if r.err != nil {
-			return nil, byhash, errors.Wrap(r.err, "download")
+			return nil, errors.Wrap(r.err, "download")
 		}
 
 		if 400 <= r.status && r.status < 500 {
@@ -426,32 +671,277 @@ func (m *Mirror) downloadRelease(ctx context.Context, suite string) (map[string]
 		}
 
 		if r.status != http.StatusOK {
-			return nil, byhash, fmt.Errorf("status %d for %s", r.status, r.path)
+			return nil, fmt.Errorf("status %d for %s", r.status, r.path)
 		}
 
-		// 200 OK
-		err := m.storage.Store(r.fi, r.data)
+		// 200 OK.  Release files are small enough to be kept in memory.
+		data, err := ioutil.ReadFile(r.tmpfile)
+		os.Remove(r.tmpfile)
 		if err != nil {
-			return nil, byhash, errors.Wrap(err, "storage.Store")
+			return nil, errors.Wrap(err, "ReadFile: "+r.path)
+		}
+		r.data = data
+		rl = append(rl, r)
+	}
+
+	if m.keyring != nil {
+		return m.verifyRelease(suite, rl)
+	}
+
+	si := &suiteIndices{
+		suite:    suite,
+		indexMap: make(map[string][]*apt.FileInfo),
+		byhash:   true,
+	}
+	for _, r := range rl {
+		// only the signed text of InRelease is used even without
+		// signature verification.
+		text := r.data
+		if path.Base(r.path) == "InRelease" {
+			t, err := apt.SignedText(r.data)
+			if err != nil {
+				return nil, errors.Wrap(err, r.path)
+			}
+			text = t
 		}
-		fil, d, err := apt.ExtractFileInfo(r.path, bytes.NewReader(r.data))
+
+		_, d, err := apt.ExtractFileInfo(r.path, bytes.NewReader(text))
 		if err != nil {
-			return nil, byhash, errors.Wrap(err, "ExtractFileInfo: "+r.path)
+			return nil, errors.Wrap(err, "ExtractFileInfo: "+r.path)
 		}
 
-		if byhash && path.Base(r.path) != "Release.gpg" {
-			byhash = apt.SupportByHash(d)
+		var rel *apt.Release
+		if path.Base(r.path) != "Release.gpg" {
+			rel, err = m.checkRelease(r.path, d)
+			if err != nil {
+				return nil, err
+			}
 		}
 
-		for _, fi := range fil {
-			err = addFileInfoToList(fi, filMap, byhash)
+		err = m.storage.Store(r.fi, r.data)
+		if err != nil {
+			return nil, errors.Wrap(err, "storage.Store")
+		}
+
+		if rel == nil {
+			continue
+		}
+		err = si.setRelease(r.path, text)
+		if err != nil {
+			return nil, err
+		}
+		if si.byhash {
+			si.byhash = rel.AcquireByHash
+		}
+		for _, fi := range rel.Files {
+			err = addFileInfoToList(fi, si.indexMap, si.byhash)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return si, nil
+}
+
+// checkRelease parses a paragraph of Release at p, and rejects it
+// if it has expired or if its Date goes backwards compared to the
+// currently mirrored one.
+func (m *Mirror) checkRelease(p string, d apt.Paragraph) (*apt.Release, error) {
+	rel, err := apt.ParseRelease(p, d)
+	if err != nil {
+		return nil, errors.Wrap(err, "ParseRelease")
+	}
+	err = rel.CheckFresh(time.Now())
+	if err != nil {
+		return nil, errors.Wrap(err, p)
+	}
+
+	oldD := m.currentRelease(p)
+	if oldD == nil {
+		return rel, nil
+	}
+	old, err := apt.ParseRelease(p, oldD)
+	if err != nil {
+		return rel, nil
+	}
+
+	err = rel.CheckNewer(old)
+	if err != nil {
+		return nil, errors.Wrap(err, p)
+	}
+	return rel, nil
+}
+
+// currentRelease reads the paragraph of Release at p in the current
+// mirror.  If the mirror has a keyring, the signature of Release is
+// verified again with the keyring or the local signing key, as
+// Release may have been regenerated and signed locally.
+// This returns nil if no trusted Release is found.
+func (m *Mirror) currentRelease(p string) apt.Paragraph {
+	if m.current == nil {
+		return nil
+	}
+	data, err := readStoredFile(m.current, p)
+	if err != nil {
+		// not mirrored yet
+		return nil
+	}
+
+	if m.keyring == nil {
+		if path.Base(p) == "InRelease" {
+			data, err = apt.SignedText(data)
+			if err != nil {
+				return nil
+			}
+		}
+		_, d, err := apt.ExtractFileInfo(p, bytes.NewReader(data))
+		if err != nil {
+			return nil
+		}
+		return d
+	}
+
+	var sig []byte
+	switch path.Base(p) {
+	case "InRelease":
+	case "Release":
+		sig, err = readStoredFile(m.current, p+".gpg")
+		if err != nil {
+			return nil
+		}
+	default:
+		return nil
+	}
+
+	keyrings := []*apt.Keyring{m.keyring}
+	if m.signer != nil {
+		keyrings = append(keyrings, m.signer.Keyring())
+	}
+	var d apt.Paragraph
+	for _, kr := range keyrings {
+		if sig == nil {
+			_, d, err = kr.VerifyInRelease(data)
+		} else {
+			_, d, err = kr.VerifyRelease(data, sig)
+		}
+		if err == nil {
+			return d
+		}
+	}
+	if err != nil {
+		log.Warn("ignored unverified Release of the current mirror", map[string]interface{}{
+			"repo":  m.id,
+			"path":  p,
+			"error": err.Error(),
+		})
+		return nil
+	}
+	return nil
+}
+
+// readStoredFile reads the whole contents of p in s.
+func readStoredFile(s *Storage, p string) ([]byte, error) {
+	f, err := s.Open(p)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ioutil.ReadAll(f)
+}
+
+// verifyRelease verifies signatures of downloaded Release and InRelease
+// with the keyring of the mirror.
+//
+// Only files covered by a valid signature are stored, and only
+// checksums and the paragraph in the signed text are returned.
+// Compressed variants of Release files are ignored as they are
+// not signed.
+func (m *Mirror) verifyRelease(suite string, rl []*dlResult) (*suiteIndices, error) {
+	files := make(map[string]*dlResult)
+	for _, r := range rl {
+		files[path.Base(r.path)] = r
+	}
+
+	si := &suiteIndices{
+		suite:    suite,
+		indexMap: make(map[string][]*apt.FileInfo),
+		byhash:   true,
+	}
+
+	add := func(p, fpr string, text []byte, d apt.Paragraph, signed ...*dlResult) error {
+		log.Info("verified signature", map[string]interface{}{
+			"repo":        m.id,
+			"path":        p,
+			"fingerprint": fpr,
+		})
+		rel, err := m.checkRelease(p, d)
+		if err != nil {
+			return err
+		}
+		for _, r := range signed {
+			err := m.storage.Store(r.fi, r.data)
 			if err != nil {
-				return nil, byhash, err
+				return errors.Wrap(err, "storage.Store")
+			}
+		}
+
+		err = si.setRelease(p, text)
+		if err != nil {
+			return err
+		}
+		if si.byhash {
+			si.byhash = rel.AcquireByHash
+		}
+		for _, fi := range rel.Files {
+			err = addFileInfoToList(fi, si.indexMap, si.byhash)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	if r, ok := files["InRelease"]; ok {
+		fpr, d, err := m.keyring.VerifyInRelease(r.data)
+		if err != nil {
+			log.Warn("bad signature", map[string]interface{}{
+				"repo":  m.id,
+				"path":  r.path,
+				"error": err.Error(),
+			})
+		} else {
+			// SignedText never fails for verified data.
+			text, _ := apt.SignedText(r.data)
+			err = add(r.path, fpr, text, d, r)
+			if err != nil {
+				return nil, err
 			}
 		}
 	}
 
-	return filMap, byhash, nil
+	r, ok := files["Release"]
+	sig, sigOk := files["Release.gpg"]
+	if ok && sigOk {
+		fpr, d, err := m.keyring.VerifyRelease(r.data, sig.data)
+		if err != nil {
+			log.Warn("bad signature", map[string]interface{}{
+				"repo":  m.id,
+				"path":  sig.path,
+				"error": err.Error(),
+			})
+		} else {
+			err = add(r.path, fpr, r.data, d, r, sig)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if si.release == nil {
+		return nil, errors.New("no valid signature for Release of " + suite)
+	}
+	return si, nil
 }
 
 func (m *Mirror) downloadIndices(ctx context.Context,
@@ -498,6 +988,8 @@ func (m *Mirror) downloadFiles(ctx context.Context,
 	env.Stop()
 	err := env.Wait()
 	if err != nil {
+		// results is closed by now.
+		discardResults(results)
 		return nil, err
 	}
 
@@ -512,6 +1004,33 @@ func (m *Mirror) downloadFiles(ctx context.Context,
 	return append(reused, downloaded...), nil
 }
 
+// reuse looks up fi in the current mirror and in the incomplete one
+// left by an interrupted update.  If found, the file is linked into
+// the new mirror and its FileInfo is returned.
+func (m *Mirror) reuse(fi *apt.FileInfo, byhash bool) (*apt.FileInfo, error) {
+	for _, st := range []*Storage{m.current, m.incomplete} {
+		if st == nil {
+			continue
+		}
+		localfi, fullpath := st.Lookup(fi, byhash)
+		if localfi == nil {
+			continue
+		}
+		err := m.storeLink(localfi, fullpath, byhash)
+		if err != nil {
+			return nil, errors.Wrap(err, "storeLink")
+		}
+		if log.Enabled(log.LvDebug) {
+			log.Debug("reuse item", map[string]interface{}{
+				"repo": m.id,
+				"path": fi.Path(),
+			})
+		}
+		return localfi, nil
+	}
+	return nil, nil
+}
+
 func (m *Mirror) reuseOrDownload(ctx context.Context, fil []*apt.FileInfo,
 	byhash bool, results chan<- *dlResult) ([]*apt.FileInfo, error) {
 
@@ -543,22 +1062,17 @@ func (m *Mirror) reuseOrDownload(ctx context.Context, fil []*apt.FileInfo,
 			})
 		}
 
-		if m.current != nil {
-			localfi, fullpath := m.current.Lookup(fi, byhash)
-			if localfi != nil {
-				err := m.storeLink(localfi, fullpath, byhash)
-				if err != nil {
-					return nil, errors.Wrap(err, "storeLink")
-				}
-				reused = append(reused, localfi)
-				if log.Enabled(log.LvDebug) {
-					log.Debug("reuse item", map[string]interface{}{
-						"repo": m.id,
-						"path": fi.Path(),
-					})
-				}
-				continue
-			}
+		if err := m.checkChecksum(fi); err != nil {
+			return nil, err
+		}
+
+		localfi, err := m.reuse(fi, byhash)
+		if err != nil {
+			return nil, err
+		}
+		if localfi != nil {
+			reused = append(reused, localfi)
+			continue
 		}
 
 		select {
@@ -575,6 +1089,33 @@ func (m *Mirror) reuseOrDownload(ctx context.Context, fil []*apt.FileInfo,
 	return reused, nil
 }
 
+// checkChecksum returns an error if fi lacks a checksum as strong
+// as the configured minimum.  Without this, FileInfo.Same would
+// accept a file that matches only by MD5 or size.
+func (m *Mirror) checkChecksum(fi *apt.FileInfo) error {
+	ct := fi.StrongestChecksum()
+	if ct >= m.minChecksum {
+		return nil
+	}
+	log.Error("weak checksum", map[string]interface{}{
+		"repo":     m.id,
+		"path":     fi.Path(),
+		"checksum": ct.String(),
+		"required": m.minChecksum.String(),
+	})
+	return errors.Errorf("no %s checksum for %s", m.minChecksum, fi.Path())
+}
+
+// discardResults removes temporary files of downloads that were
+// not received by recvResult.  results must be closed.
+func discardResults(results <-chan *dlResult) {
+	for r := range results {
+		if len(r.tmpfile) > 0 {
+			os.Remove(r.tmpfile)
+		}
+	}
+}
+
 func (m *Mirror) recvResult(allowMissing, byhash bool, results <-chan *dlResult) ([]*apt.FileInfo, error) {
 	var dlfil []*apt.FileInfo
 
@@ -595,9 +1136,10 @@ func (m *Mirror) recvResult(allowMissing, byhash bool, results <-chan *dlResult)
 			return nil, fmt.Errorf("status %d for %s", r.status, r.path)
 		}
 
-		err := m.store(r.fi, r.data, byhash)
+		err := m.storeLink(r.fi, r.tmpfile, byhash)
+		os.Remove(r.tmpfile)
 		if err != nil {
-			return nil, errors.Wrap(err, "store")
+			return nil, errors.Wrap(err, "storeLink")
 		}
 
 		dlfil = append(dlfil, r.fi)
@@ -1,11 +1,20 @@
 package mirror
 
 import (
+	"bytes"
 	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/BurntSushi/toml"
+	"github.com/cybozu-go/aptutil/apt"
 )
 
 func TestMirror(t *testing.T) {
@@ -34,3 +43,547 @@ func TestMirror(t *testing.T) {
 		t.Error(err)
 	}
 }
+
+func testDLResult(t *testing.T, p, fn string) *dlResult {
+	data, err := ioutil.ReadFile(fn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &dlResult{
+		status: 200,
+		path:   p,
+		fi:     apt.MakeFileInfo(p, data),
+		data:   data,
+	}
+}
+
+func TestVerifyRelease(t *testing.T) {
+	t.Parallel()
+
+	d, err := ioutil.TempDir("", "gotest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(d)
+
+	kr, err := apt.LoadKeyring("../apt/testdata/gpg/keyring.asc")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	newMirror := func() *Mirror {
+		s, err := NewStorage(d, "test")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return &Mirror{id: "test", storage: s, keyring: kr}
+	}
+
+	inRelease := testDLResult(t, "dists/testing/InRelease", "../apt/testdata/gpg/InRelease")
+	release := testDLResult(t, "dists/testing/Release", "../apt/testdata/gpg/Release")
+	releaseGPG := testDLResult(t, "dists/testing/Release.gpg", "../apt/testdata/gpg/Release.gpg")
+	other := testDLResult(t, "dists/testing/InRelease", "../apt/testdata/gpg/InRelease.other")
+	tampered := testDLResult(t, "dists/testing/InRelease", "../apt/testdata/gpg/InRelease")
+	tampered.data = bytes.Replace(tampered.data,
+		[]byte("Codename: testing"), []byte("Codename: hacked!"), 1)
+
+	m := newMirror()
+	si, err := m.verifyRelease("testing", []*dlResult{inRelease})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(si.indexMap) != 9 {
+		t.Error(`len(si.indexMap) != 9`)
+	}
+	if si.release == nil || si.release.Paragraph()["Codename"][0] != "testing" {
+		t.Error(`release must be read from the signed text`)
+	}
+
+	m = newMirror()
+	_, err = m.verifyRelease("testing", []*dlResult{other})
+	if err == nil {
+		t.Error(`InRelease signed by unknown key must not be accepted`)
+	}
+
+	m = newMirror()
+	_, err = m.verifyRelease("testing", []*dlResult{tampered, release})
+	if err == nil {
+		t.Error(`Release without Release.gpg must not be accepted`)
+	}
+
+	m = newMirror()
+	si, err = m.verifyRelease("testing", []*dlResult{tampered, release, releaseGPG})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(si.indexMap) != 9 {
+		t.Error(`len(si.indexMap) != 9`)
+	}
+	if fi, _ := m.storage.Lookup(tampered.fi, false); fi != nil {
+		t.Error(`tampered InRelease must not be stored`)
+	}
+	if fi, _ := m.storage.Lookup(release.fi, false); fi == nil {
+		t.Error(`verified Release must be stored`)
+	}
+}
+
+func TestCheckRelease(t *testing.T) {
+	t.Parallel()
+
+	d, err := ioutil.TempDir("", "gotest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(d)
+
+	current, err := NewStorage(d, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := []byte("Date: Sat, 05 Aug 2017 00:00:00 UTC\n")
+	err = current.Store(apt.MakeFileInfo("dists/stretch/Release", data), data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a paragraph prepended to the signed text must be ignored.
+	inRelease, err := ioutil.ReadFile("../apt/testdata/gpg/InRelease")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data = append([]byte("Date: Fri, 01 Jan 2100 00:00:00 UTC\n\n"), inRelease...)
+	err = current.Store(apt.MakeFileInfo("dists/testing/InRelease", data), data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := &Mirror{id: "test", current: current}
+
+	_, err = m.checkRelease("dists/stretch/Release", apt.Paragraph{
+		"Date": {"Sun, 06 Aug 2017 00:00:00 UTC"},
+	})
+	if err != nil {
+		t.Error(err)
+	}
+
+	_, err = m.checkRelease("dists/stretch/Release", apt.Paragraph{
+		"Date": {"Fri, 04 Aug 2017 00:00:00 UTC"},
+	})
+	if err == nil {
+		t.Error(`Release going backwards must be rejected`)
+	}
+
+	_, err = m.checkRelease("dists/buster/Release", apt.Paragraph{
+		"Date": {"Fri, 04 Aug 2017 00:00:00 UTC"},
+	})
+	if err != nil {
+		t.Error(err)
+	}
+
+	_, err = m.checkRelease("dists/testing/InRelease", apt.Paragraph{
+		"Date": {"Sun, 06 Aug 2017 00:00:00 UTC"},
+	})
+	if err != nil {
+		t.Error(`unsigned paragraph must not be compared`, err)
+	}
+
+	_, err = m.checkRelease("dists/buster/Release", apt.Paragraph{
+		"Date":        {"Fri, 04 Aug 2017 00:00:00 UTC"},
+		"Valid-Until": {"Sat, 05 Aug 2017 00:00:00 UTC"},
+	})
+	if err == nil {
+		t.Error(`expired Release must be rejected`)
+	}
+}
+
+func TestCheckChecksum(t *testing.T) {
+	t.Parallel()
+
+	m := &Mirror{id: "test", minChecksum: apt.ChecksumSHA256}
+
+	data := []byte("hello")
+	if err := m.checkChecksum(apt.MakeFileInfo("a/b", data)); err != nil {
+		t.Error(err)
+	}
+	if err := m.checkChecksum(apt.MakeFileInfoNoChecksum("a/b", 5)); err == nil {
+		t.Error(`file without checksums must be rejected`)
+	}
+
+	fil, err := apt.FileInfoFromRelease("dists/stretch/Release", apt.Paragraph{
+		"MD5Sum": {"d41d8cd98f00b204e9800998ecf8427e 0 main/binary-amd64/Packages"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.checkChecksum(fil[0]); err == nil {
+		t.Error(`file with only MD5 must be rejected`)
+	}
+
+	m.minChecksum = apt.ChecksumNone
+	if err := m.checkChecksum(fil[0]); err != nil {
+		t.Error(err)
+	}
+}
+
+// testMirror returns a Mirror that downloads files served by h
+// into a new storage in a temporary directory.
+//
+// The returned function stops the server and removes the directory.
+func testMirror(t *testing.T, h http.Handler) (*Mirror, func()) {
+	ts := httptest.NewServer(h)
+
+	u := new(tomlURL)
+	if err := u.UnmarshalText([]byte(ts.URL)); err != nil {
+		ts.Close()
+		t.Fatal(err)
+	}
+
+	d, err := ioutil.TempDir("", "gotest")
+	if err != nil {
+		ts.Close()
+		t.Fatal(err)
+	}
+	cleanup := func() {
+		ts.Close()
+		os.RemoveAll(d)
+	}
+
+	storage, err := NewStorage(d, "test")
+	if err != nil {
+		cleanup()
+		t.Fatal(err)
+	}
+
+	m := &Mirror{
+		id:        "test",
+		mc:        &MirrConfig{URL: *u},
+		storage:   storage,
+		semaphore: make(chan struct{}, 2),
+		client:    &http.Client{},
+	}
+	return m, cleanup
+}
+
+func TestDownload(t *testing.T) {
+	t.Parallel()
+
+	m, cleanup := testMirror(t, http.FileServer(http.Dir("../apt/testdata/af")))
+	defer cleanup()
+
+	data, err := ioutil.ReadFile("../apt/testdata/af/Packages")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fi := apt.MakeFileInfo("Packages", data)
+	bad := apt.MakeFileInfo("Packages", []byte("hello"))
+
+	ctx := context.Background()
+	results := make(chan *dlResult, 2)
+	m.download(ctx, "Packages", bad, false, results)
+	r := <-results
+	if r.err == nil {
+		t.Error(`invalid checksum must be detected`)
+	}
+
+	m.download(ctx, "Packages", fi, false, results)
+	close(results)
+	fil, err := m.recvResult(false, false, results)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(fil) != 1 || !fil[0].Same(fi) {
+		t.Error(`len(fil) != 1 || !fil[0].Same(fi)`)
+	}
+
+	f, err := m.storage.Open("Packages")
+	if err != nil {
+		t.Fatal(err)
+	}
+	stored, err := ioutil.ReadAll(f)
+	st, _ := f.Stat()
+	f.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(stored, data) {
+		t.Error(`!bytes.Equal(stored, data)`)
+	}
+	if st.Mode().Perm() != 0644 {
+		t.Error(`st.Mode().Perm() != 0644`, st.Mode())
+	}
+
+	// no temporary files should be left.
+	dentries, err := ioutil.ReadDir(m.storage.Dir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(dentries) != 1 {
+		t.Error(`temporary files remain`, len(dentries))
+	}
+}
+
+func TestDownloadResume(t *testing.T) {
+	t.Parallel()
+
+	data := bytes.Repeat([]byte("0123456789"), 10000)
+	var requests, ranges int32
+	m, cleanup := testMirror(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("ETag", `"abc"`)
+		if atomic.AddInt32(&requests, 1) == 1 {
+			// cut the connection halfway.
+			w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+			w.Write(data[:len(data)/2])
+			w.(http.Flusher).Flush()
+			panic(http.ErrAbortHandler)
+		}
+		if len(r.Header.Get("Range")) > 0 {
+			atomic.AddInt32(&ranges, 1)
+		}
+		http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(data))
+	}))
+	defer cleanup()
+
+	fi := apt.MakeFileInfo("pool/a.deb", data)
+	results := make(chan *dlResult, 1)
+	m.download(context.Background(), "pool/a.deb", fi, false, results)
+	r := <-results
+	if r.err != nil {
+		t.Fatal(r.err)
+	}
+	defer os.Remove(r.tmpfile)
+
+	if !fi.Same(r.fi) {
+		t.Error(`!fi.Same(r.fi)`)
+	}
+	if atomic.LoadInt32(&ranges) != 1 {
+		t.Error(`download was not resumed`)
+	}
+	stored, err := ioutil.ReadFile(r.tmpfile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(stored, data) {
+		t.Error(`!bytes.Equal(stored, data)`)
+	}
+}
+
+func TestDownloadResumeNextUpdate(t *testing.T) {
+	t.Parallel()
+
+	data := bytes.Repeat([]byte("0123456789"), 10000)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var requests, ranges int32
+	m, cleanup := testMirror(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("ETag", `"abc"`)
+		if atomic.AddInt32(&requests, 1) == 1 {
+			// the update is interrupted halfway.
+			w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+			w.Write(data[:len(data)/2])
+			w.(http.Flusher).Flush()
+			// wait for the client to receive the data.
+			time.Sleep(100 * time.Millisecond)
+			cancel()
+			panic(http.ErrAbortHandler)
+		}
+		if len(r.Header.Get("Range")) > 0 {
+			atomic.AddInt32(&ranges, 1)
+		}
+		http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(data))
+	}))
+	defer cleanup()
+
+	fi := apt.MakeFileInfo("pool/a.deb", data)
+	results := make(chan *dlResult, 1)
+	m.download(ctx, "pool/a.deb", fi, false, results)
+	r := <-results
+	if r.err == nil {
+		t.Fatal(`interrupted download must fail`)
+	}
+
+	// the next update finds the partial file in the incomplete mirror.
+	d, err := ioutil.TempDir("", "gotest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(d)
+	storage, err := NewStorage(d, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	m2 := &Mirror{
+		id:         "test",
+		mc:         m.mc,
+		storage:    storage,
+		incomplete: m.storage,
+		semaphore:  make(chan struct{}, 2),
+		client:     &http.Client{},
+	}
+
+	m2.download(context.Background(), "pool/a.deb", fi, false, results)
+	r = <-results
+	if r.err != nil {
+		t.Fatal(r.err)
+	}
+	defer os.Remove(r.tmpfile)
+
+	if !fi.Same(r.fi) {
+		t.Error(`!fi.Same(r.fi)`)
+	}
+	if atomic.LoadInt32(&ranges) != 1 {
+		t.Error(`download was not resumed`)
+	}
+	stored, err := ioutil.ReadFile(r.tmpfile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(stored, data) {
+		t.Error(`!bytes.Equal(stored, data)`)
+	}
+
+	kept, err := ioutil.ReadDir(filepath.Join(m.storage.Dir(), partialDir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(kept) != 0 {
+		t.Error(`partial file must be taken over`, len(kept))
+	}
+}
+
+func TestDownloadByHashFallback(t *testing.T) {
+	t.Parallel()
+
+	data := []byte("Package: a\n")
+	fi := apt.MakeFileInfo("dists/x/Packages", data)
+	sha256Path := "/" + fi.SHA256Path()
+	m, cleanup := testMirror(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/dists/x/Packages":
+			// updated during the mirror update
+			w.Write([]byte("Package: b\n"))
+		case sha256Path:
+			w.Write(data)
+		default:
+			// no by-hash/SHA512
+			http.NotFound(w, r)
+		}
+	}))
+	defer cleanup()
+
+	results := make(chan *dlResult, 1)
+	m.download(context.Background(), fi.Path(), fi, true, results)
+	r := <-results
+	if r.err != nil {
+		t.Fatal(r.err)
+	}
+	defer os.Remove(r.tmpfile)
+	if r.status != http.StatusOK || !fi.Same(r.fi) {
+		t.Error(`by-hash/SHA256 must be used`, r.status)
+	}
+}
+
+func TestDiscardResults(t *testing.T) {
+	t.Parallel()
+
+	d, err := ioutil.TempDir("", "gotest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(d)
+
+	storage, err := NewStorage(d, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := storage.TempFile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	results := make(chan *dlResult, 3)
+	results <- &dlResult{status: http.StatusNotFound, path: "missing"}
+	results <- &dlResult{status: http.StatusOK, path: "data", tmpfile: f.Name()}
+	close(results)
+
+	m := &Mirror{id: "test", storage: storage}
+	if _, err := m.recvResult(false, false, results); err == nil {
+		t.Fatal(`missing file must be an error`)
+	}
+	discardResults(results)
+
+	if _, err := os.Stat(f.Name()); !os.IsNotExist(err) {
+		t.Error(`temporary file remains`)
+	}
+}
+
+func TestDownloadReleaseFailure(t *testing.T) {
+	t.Parallel()
+
+	failed := make(chan struct{})
+	m, cleanup := testMirror(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/dists/testing/Release" {
+			w.WriteHeader(http.StatusNoContent)
+			close(failed)
+			return
+		}
+		// respond after the failure is received.
+		<-failed
+		time.Sleep(100 * time.Millisecond)
+		w.Write([]byte("Origin: test\n"))
+	}))
+	defer cleanup()
+	m.mc.Suites = []string{"testing"}
+	for i := 0; i < cap(m.semaphore); i++ {
+		m.semaphore <- struct{}{}
+	}
+
+	if _, err := m.downloadRelease(context.Background(), "testing"); err == nil {
+		t.Fatal(`unexpected status must be an error`)
+	}
+
+	dentries, err := ioutil.ReadDir(m.storage.Dir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(dentries) != 0 {
+		t.Error(`temporary files remain`, len(dentries))
+	}
+}
+
+func TestFindIncomplete(t *testing.T) {
+	t.Parallel()
+
+	d, err := ioutil.TempDir("", "gotest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(d)
+
+	incomplete, err := findIncomplete(d, "ubuntu", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(incomplete) != 0 {
+		t.Error(`len(incomplete) != 0`)
+	}
+
+	for _, name := range []string{
+		".ubuntu.20170801_000000",
+		".ubuntu.20170802_000000",
+		".ubuntu.20170803_000000",
+		".ubuntu.hoge",
+		".ubuntu-security.20170804_000000",
+	} {
+		if err := os.Mkdir(filepath.Join(d, name), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	current := filepath.Join(d, ".ubuntu.20170803_000000")
+	incomplete, err = findIncomplete(d, "ubuntu", current)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if incomplete != filepath.Join(d, ".ubuntu.20170802_000000") {
+		t.Error(`wrong incomplete directory`, incomplete)
+	}
+}
@@ -0,0 +1,286 @@
+package mirror
+
+// This file implements partial downloads that can be resumed
+// by HTTP range requests.
+//
+// Partial files left by failed downloads are kept in the mirror
+// directory together with their validators, so that the next update
+// can resume them through the incomplete mirror.
+//
+// Specifications are:
+// https://tools.ietf.org/html/rfc7233
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/cybozu-go/aptutil/apt"
+	"github.com/cybozu-go/log"
+	"github.com/pkg/errors"
+)
+
+const (
+	partialBufferSize = 32 * 1024
+
+	// partialDir is the directory in a mirror directory to keep
+	// partial files for the next update.
+	partialDir = ".partial"
+)
+
+// partialInfo is the metadata of a kept partial file.
+type partialInfo struct {
+	Path      string `json:"path"`
+	Validator string `json:"validator"`
+	Size      int64  `json:"size"`
+}
+
+// partialName returns the file name to keep the partial file of p.
+func partialName(p string) string {
+	sum := sha256.Sum256([]byte(p))
+	return hex.EncodeToString(sum[:])
+}
+
+// partial is a partially downloaded file kept in a temporary file.
+//
+// Checksums are calculated as data are appended, therefore the
+// whole file need not be read again when the download completes.
+type partial struct {
+	f    *os.File
+	w    *apt.FileInfoWriter
+	size int64
+
+	// validator is an ETag or Last-Modified for If-Range header.
+	validator string
+
+	// broken is set when the file cannot be resumed.
+	broken bool
+}
+
+// newPartial creates a partial download for p from a 200 OK response.
+func (m *Mirror) newPartial(p string, resp *http.Response) (*partial, error) {
+	f, err := m.storage.TempFile()
+	if err != nil {
+		return nil, err
+	}
+
+	// weak ETags cannot be used with If-Range.
+	validator := resp.Header.Get("ETag")
+	if len(validator) == 0 || strings.HasPrefix(validator, "W/") {
+		validator = resp.Header.Get("Last-Modified")
+	}
+
+	return &partial{
+		f:         f,
+		w:         apt.NewFileInfoWriter(p),
+		validator: validator,
+	}, nil
+}
+
+// resumable returns true if the download can be resumed.
+//
+// If fi is not nil and has the size, the partial data must be
+// shorter than fi.
+func (pt *partial) resumable(fi *apt.FileInfo) bool {
+	if pt.broken || pt.size == 0 || len(pt.validator) == 0 {
+		return false
+	}
+	if fi != nil && fi.HasSize() && uint64(pt.size) >= fi.Size() {
+		return false
+	}
+	return true
+}
+
+// setRange sets headers to request the rest of the file.
+func (pt *partial) setRange(h http.Header) {
+	h.Set("Range", fmt.Sprintf("bytes=%d-", pt.size))
+	h.Set("If-Range", pt.validator)
+}
+
+// accepts returns true if a 206 Partial Content response continues
+// the partial data.
+func (pt *partial) accepts(resp *http.Response) bool {
+	var start, end int64
+	var total string
+	cr := resp.Header.Get("Content-Range")
+	_, err := fmt.Sscanf(cr, "bytes %d-%d/%s", &start, &end, &total)
+	return err == nil && start == pt.size
+}
+
+// fill appends data read from body until EOF or an error.
+func (pt *partial) fill(body io.Reader) error {
+	buf := make([]byte, partialBufferSize)
+	for {
+		n, err := body.Read(buf)
+		if n > 0 {
+			if _, werr := pt.f.Write(buf[:n]); werr != nil {
+				// the file and checksums may be inconsistent.
+				pt.broken = true
+				return werr
+			}
+			pt.w.Write(buf[:n])
+			pt.size += int64(n)
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// finish closes the temporary file and returns its FileInfo and name.
+//
+// The caller is responsible for removing the file.
+func (pt *partial) finish() (*apt.FileInfo, string, error) {
+	err := pt.f.Sync()
+	if err2 := pt.f.Close(); err == nil {
+		err = err2
+	}
+	if err != nil {
+		os.Remove(pt.f.Name())
+		return nil, "", errors.Wrap(err, "partial.finish")
+	}
+	return pt.w.FileInfo(), pt.f.Name(), nil
+}
+
+// discard removes the temporary file.
+func (pt *partial) discard() {
+	pt.f.Close()
+	os.Remove(pt.f.Name())
+}
+
+// keep moves the temporary file to dir together with its metadata
+// so that the next update can resume the download of p.
+func (pt *partial) keep(dir, p string) error {
+	err := pt.f.Sync()
+	if err2 := pt.f.Close(); err == nil {
+		err = err2
+	}
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(dir, 0755)
+	if err != nil {
+		return err
+	}
+	name := filepath.Join(dir, partialName(p))
+	data, err := json.Marshal(&partialInfo{
+		Path:      p,
+		Validator: pt.validator,
+		Size:      pt.size,
+	})
+	if err != nil {
+		return err
+	}
+	err = ioutil.WriteFile(name+".json", data, 0644)
+	if err != nil {
+		return err
+	}
+	return os.Rename(pt.f.Name(), name)
+}
+
+// keepPartial keeps pt for the next update if it can be resumed,
+// or removes it.
+func (m *Mirror) keepPartial(p string, pt *partial) {
+	if !pt.resumable(nil) {
+		pt.discard()
+		return
+	}
+
+	err := pt.keep(filepath.Join(m.storage.Dir(), partialDir), p)
+	if err != nil {
+		log.Warn("could not keep partial file", map[string]interface{}{
+			"repo":  m.id,
+			"path":  p,
+			"error": err.Error(),
+		})
+		os.Remove(pt.f.Name())
+		return
+	}
+	log.Info("kept partial file", map[string]interface{}{
+		"repo": m.id,
+		"path": p,
+		"size": pt.size,
+	})
+}
+
+// loadPartial takes over the partial file of p kept by an interrupted
+// update, or returns nil if not found.
+func (m *Mirror) loadPartial(p string) *partial {
+	if m.incomplete == nil {
+		return nil
+	}
+	name := filepath.Join(m.incomplete.Dir(), partialDir, partialName(p))
+	data, err := ioutil.ReadFile(name + ".json")
+	if err != nil {
+		return nil
+	}
+
+	// a partial file is taken over only once.
+	os.Remove(name + ".json")
+	pt, err := m.openPartial(p, name, data)
+	if err != nil {
+		os.Remove(name)
+		log.Warn("could not resume partial file", map[string]interface{}{
+			"repo":  m.id,
+			"path":  p,
+			"error": err.Error(),
+		})
+		return nil
+	}
+	return pt
+}
+
+// openPartial moves the kept partial file name to the storage, and
+// calculates checksums of the data in it.  data is its metadata.
+func (m *Mirror) openPartial(p, name string, data []byte) (*partial, error) {
+	var info partialInfo
+	err := json.Unmarshal(data, &info)
+	if err != nil {
+		return nil, err
+	}
+	if info.Path != p {
+		return nil, errors.New("path mismatch: " + info.Path)
+	}
+
+	f, err := m.storage.TempFile()
+	if err != nil {
+		return nil, err
+	}
+	f.Close()
+	err = os.Rename(name, f.Name())
+	if err != nil {
+		os.Remove(f.Name())
+		return nil, err
+	}
+	f, err = os.OpenFile(f.Name(), os.O_RDWR, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	pt := &partial{
+		f:         f,
+		w:         apt.NewFileInfoWriter(p),
+		validator: info.Validator,
+	}
+	n, err := io.Copy(pt.w, f)
+	if err == nil && n != info.Size {
+		err = errors.New("size mismatch")
+	}
+	if err != nil {
+		pt.discard()
+		return nil, err
+	}
+	pt.size = n
+	return pt, nil
+}
@@ -0,0 +1,431 @@
+package mirror
+
+// This file implements rewriting of Packages, Sources, and Release
+// for mirrors that contain only a part of packages.
+//
+// Rewritten indices cannot be signed by the upstream keys.
+// Therefore, InRelease and Release.gpg are signed by the local
+// signing key if configured, or removed otherwise.
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+
+	"github.com/cybozu-go/aptutil/apt"
+	"github.com/cybozu-go/log"
+	"github.com/pkg/errors"
+	"github.com/ulikunitz/xz"
+)
+
+var (
+	releaseChecksumFields = []struct {
+		name string
+		ct   apt.ChecksumType
+	}{
+		{"MD5Sum", apt.ChecksumMD5},
+		{"SHA1", apt.ChecksumSHA1},
+		{"SHA256", apt.ChecksumSHA256},
+		{"SHA512", apt.ChecksumSHA512},
+	}
+
+	// extensions of rewritten indices.
+	rewrittenExts = []string{"", ".gz", ".xz"}
+)
+
+// stanza is a paragraph of Packages or Sources.
+type stanza struct {
+	p    *apt.OrderedParagraph
+	d    apt.Paragraph
+	keep bool
+}
+
+// readStanzas reads paragraphs from a control file.
+func readStanzas(r io.Reader) ([]*stanza, error) {
+	var sl []*stanza
+	p := apt.NewParser(r)
+	for {
+		op, err := p.ReadOrdered()
+		if err == io.EOF {
+			return sl, nil
+		}
+		if err != nil {
+			return nil, errors.Wrap(err, "readStanzas")
+		}
+		sl = append(sl, &stanza{p: op, d: op.Paragraph()})
+	}
+}
+
+// indexGroup is a set of compression variants of Packages or Sources
+// in a directory.
+type indexGroup struct {
+	// path is the path of the index without compression extension.
+	path   string
+	source bool
+
+	// stored is the list of stored variants.
+	stored []*apt.FileInfo
+
+	stanzas []*stanza
+}
+
+// data returns the contents of the rewritten index.
+func (g *indexGroup) data() ([]byte, error) {
+	var buf bytes.Buffer
+	w := apt.NewWriter(&buf)
+	for _, s := range g.stanzas {
+		if !s.keep {
+			continue
+		}
+		if err := w.WriteParagraph(s.p); err != nil {
+			return nil, err
+		}
+	}
+	return buf.Bytes(), nil
+}
+
+// loadIndexGroups reads Packages and Sources matching the configuration
+// from stored indices.
+func (m *Mirror) loadIndexGroups(indices []*apt.FileInfo, byhash bool) ([]*indexGroup, error) {
+	groupMap := make(map[string]*indexGroup)
+	var groups []*indexGroup
+	for _, index := range indices {
+		p := index.Path()
+		rn := rawName(p)
+		if rn != "Packages" && rn != "Sources" {
+			continue
+		}
+		if !m.mc.MatchingIndex(p) {
+			continue
+		}
+		gp := path.Join(path.Dir(p), rn)
+		g, ok := groupMap[gp]
+		if !ok {
+			g = &indexGroup{
+				path:   gp,
+				source: rn == "Sources",
+			}
+			groupMap[gp] = g
+			groups = append(groups, g)
+		}
+		g.stored = append(g.stored, index)
+	}
+
+	for _, g := range groups {
+		for _, index := range g.stored {
+			if !apt.IsSupported(index.Path()) {
+				continue
+			}
+			sl, err := m.readIndex(index, byhash)
+			if err != nil {
+				return nil, err
+			}
+			g.stanzas = sl
+			break
+		}
+	}
+	return groups, nil
+}
+
+func (m *Mirror) readIndex(index *apt.FileInfo, byhash bool) ([]*stanza, error) {
+	p := index.Path()
+	hashPath := p
+	if byhash {
+		hashPath = index.SHA256Path()
+	}
+	f, err := m.storage.Open(hashPath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	r, err := apt.Decompress(p, f)
+	if err != nil {
+		return nil, errors.Wrap(err, p)
+	}
+	defer r.Close()
+
+	sl, err := readStanzas(r)
+	if err != nil {
+		return nil, errors.Wrap(err, p)
+	}
+	return sl, nil
+}
+
+func compress(p string, data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	var w io.WriteCloser
+	switch path.Ext(p) {
+	case "":
+		return data, nil
+	case ".gz":
+		w = gzip.NewWriter(&buf)
+	case ".xz":
+		xw, err := xz.NewWriter(&buf)
+		if err != nil {
+			return nil, err
+		}
+		w = xw
+	default:
+		return nil, errors.New("unsupported compression: " + p)
+	}
+
+	if _, err := w.Write(data); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// writeIndexGroups replaces stored indices in groups with rewritten ones.
+//
+// This returns the list of removed paths and new indices.
+func (m *Mirror) writeIndexGroups(groups []*indexGroup, byhash bool) (map[string]bool, []*apt.FileInfo, error) {
+	removed := make(map[string]bool)
+	var added []*apt.FileInfo
+
+	for _, g := range groups {
+		for _, index := range g.stored {
+			p := index.Path()
+			if removed[p] {
+				continue
+			}
+			if err := m.storage.Remove(p); err != nil {
+				return nil, nil, err
+			}
+			removed[p] = true
+		}
+
+		data, err := g.data()
+		if err != nil {
+			return nil, nil, errors.Wrap(err, g.path)
+		}
+		for _, ext := range rewrittenExts {
+			p := g.path + ext
+			cdata, err := compress(p, data)
+			if err != nil {
+				return nil, nil, errors.Wrap(err, p)
+			}
+			fi := apt.MakeFileInfo(p, cdata)
+			if byhash {
+				err = m.storage.StoreWithHash(fi, cdata)
+			} else {
+				err = m.storage.Store(fi, cdata)
+			}
+			if err != nil {
+				return nil, nil, errors.Wrap(err, p)
+			}
+			added = append(added, fi)
+		}
+	}
+	return removed, added, nil
+}
+
+// relPath returns p relative to dir.
+func relPath(dir, p string) string {
+	if dir == "." {
+		return p
+	}
+	return strings.TrimPrefix(p, dir+"/")
+}
+
+// rewriteRelease replaces Release files for si with a Release
+// whose checksums are updated for rewritten indices.
+// Indices that are not mirrored, such as Contents when mirror_contents
+// is false, are removed from the checksums.
+// Other fields are kept as they are.  Only the verified paragraph
+// in si is used, not the stored files.
+//
+// As the new Release cannot be signed by the upstream keys, InRelease
+// and Release.gpg are replaced with ones signed by the local signing
+// key if configured.  Otherwise, they are removed.
+func (m *Mirror) rewriteRelease(si *suiteIndices, removed map[string]bool, added []*apt.FileInfo) error {
+	suite := si.suite
+	op := si.release
+	if op == nil {
+		return errors.New("no Release for " + suite)
+	}
+
+	releases := m.mc.ReleaseFiles(suite)
+	dir := path.Dir(releases[0])
+	for _, cf := range releaseChecksumFields {
+		f := op.Field(cf.name)
+		if f == nil {
+			continue
+		}
+		var nl []string
+		for _, l := range f.Lines() {
+			t := strings.Fields(l)
+			if len(t) == 3 {
+				fp := path.Join(dir, t[2])
+				if removed[fp] || !m.mc.needsIndex(fp) {
+					continue
+				}
+			}
+			nl = append(nl, l)
+		}
+		for _, fi := range added {
+			csum := fi.Checksum(cf.ct)
+			if csum == nil {
+				continue
+			}
+			nl = append(nl, fmt.Sprintf("%s %d %s",
+				hex.EncodeToString(csum), fi.Size(), relPath(dir, fi.Path())))
+		}
+
+		var value string
+		for _, l := range nl {
+			value += "\n " + l
+		}
+		op.Set(f.Name, value)
+	}
+
+	signed := false
+	for _, p := range releases {
+		switch path.Base(p) {
+		case "InRelease", "Release.gpg":
+			if f, err := m.storage.Open(p); err == nil {
+				f.Close()
+				signed = true
+			}
+		}
+		if err := m.storage.Remove(p); err != nil {
+			return err
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := apt.NewWriter(&buf).WriteParagraph(op); err != nil {
+		return err
+	}
+	data := buf.Bytes()
+	err := m.storage.Store(apt.MakeFileInfo(path.Join(dir, "Release"), data), data)
+	if err != nil {
+		return err
+	}
+
+	if m.signer == nil {
+		if signed {
+			log.Warn("Release is no longer signed", map[string]interface{}{
+				"repo":  m.id,
+				"suite": suite,
+			})
+		}
+		return nil
+	}
+
+	inRelease, err := m.signer.SignInRelease(data)
+	if err != nil {
+		return err
+	}
+	sig, err := m.signer.SignRelease(data)
+	if err != nil {
+		return err
+	}
+	for name, sdata := range map[string][]byte{
+		"InRelease":   inRelease,
+		"Release.gpg": sig,
+	} {
+		p := path.Join(dir, name)
+		err = m.storage.Store(apt.MakeFileInfo(p, sdata), sdata)
+		if err != nil {
+			return err
+		}
+	}
+	log.Info("signed Release", map[string]interface{}{
+		"repo":        m.id,
+		"suite":       suite,
+		"fingerprint": m.signer.Fingerprint(),
+	})
+	return nil
+}
+
+// rewriteIndices rewrites Packages and Sources of all suites to contain
+// only selected packages, and updates Release accordingly.
+//
+// Packages are selected by the filter, then by the dependency closure
+// of the configured packages, then by the number of versions to keep,
+// if configured.  indices of sl are updated.
+func (m *Mirror) rewriteIndices(sl []*suiteIndices) error {
+	suiteGroups := make([][]*indexGroup, len(sl))
+	var groups []*indexGroup
+	for i, si := range sl {
+		g, err := m.loadIndexGroups(si.indices, si.byhash)
+		if err != nil {
+			return err
+		}
+		suiteGroups[i] = g
+		groups = append(groups, g...)
+	}
+
+	for _, g := range groups {
+		for _, s := range g.stanzas {
+			s.keep = m.filter == nil || m.filter.keep(s.d, g.source)
+		}
+	}
+
+	if len(m.mc.Packages) > 0 {
+		m.resolveClosure(groups)
+	}
+	if m.mc.KeepVersions > 0 {
+		keepNewest(groups, m.mc.KeepVersions)
+	}
+
+	total, kept := 0, 0
+	for _, g := range groups {
+		for _, s := range g.stanzas {
+			total++
+			if s.keep {
+				kept++
+			}
+		}
+	}
+	log.Info("selected packages", map[string]interface{}{
+		"repo":  m.id,
+		"total": total,
+		"kept":  kept,
+	})
+
+	for i, si := range sl {
+		err := m.writeIndices(si, suiteGroups[i])
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeIndices writes rewritten indices in groups and Release,
+// and updates indices of si.
+func (m *Mirror) writeIndices(si *suiteIndices, groups []*indexGroup) error {
+	removed, added, err := m.writeIndexGroups(groups, si.byhash)
+	if err != nil {
+		return err
+	}
+
+	err = m.rewriteRelease(si, removed, added)
+	if err != nil {
+		return err
+	}
+
+	si.indices = replaceIndices(si.indices, removed, added)
+	return nil
+}
+
+// replaceIndices returns a list of indices where removed ones
+// are replaced with added ones.
+func replaceIndices(indices []*apt.FileInfo, removed map[string]bool, added []*apt.FileInfo) []*apt.FileInfo {
+	newIndices := make([]*apt.FileInfo, 0, len(indices)+len(added))
+	for _, index := range indices {
+		if !removed[index.Path()] {
+			newIndices = append(newIndices, index)
+		}
+	}
+	return append(newIndices, added...)
+}
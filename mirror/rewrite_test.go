@@ -0,0 +1,231 @@
+package mirror
+
+import (
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/cybozu-go/aptutil/apt"
+)
+
+func TestReadStanzas(t *testing.T) {
+	t.Parallel()
+
+	sl, err := readStanzas(strings.NewReader("\nPackage: a\nVersion: 1\n\n\nPackage: b\nDescription: c\n d\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sl) != 2 {
+		t.Fatal(`len(sl) != 2`, len(sl))
+	}
+	if sl[1].d["Package"][0] != "b" {
+		t.Error(`sl[1].d["Package"][0] != "b"`)
+	}
+
+	sl[0].keep = true
+	sl[1].keep = true
+	g := &indexGroup{stanzas: sl}
+	data, err := g.data()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "Package: a\nVersion: 1\n\nPackage: b\nDescription: c\n d\n" {
+		t.Error(`unexpected data`, string(data))
+	}
+
+	sl[0].keep = false
+	data, err = g.data()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "Package: b\nDescription: c\n d\n" {
+		t.Error(`unexpected data`, string(data))
+	}
+}
+
+func TestRewriteIndices(t *testing.T) {
+	t.Parallel()
+
+	d, err := ioutil.TempDir("", "gotest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(d)
+
+	storage, err := NewStorage(d, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var indices []*apt.FileInfo
+	release := "Origin: test\nSuite: testing\nSHA256:\n"
+	for _, name := range []string{"Packages", "Packages.xz"} {
+		data, err := ioutil.ReadFile("../apt/testdata/af/" + name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fi := apt.MakeFileInfo("dists/testing/main/binary-amd64/"+name, data)
+		if err := storage.Store(fi, data); err != nil {
+			t.Fatal(err)
+		}
+		indices = append(indices, fi)
+		release += fmt.Sprintf(" %s %d main/binary-amd64/%s\n",
+			hex.EncodeToString(fi.Checksum(apt.ChecksumSHA256)), fi.Size(), name)
+	}
+	release += " 0000000000000000000000000000000000000000000000000000000000000000 0 main/i18n/Index\n"
+	for _, name := range []string{"Release", "InRelease"} {
+		// stored files must not be read again.
+		data := []byte("Origin: forged\n\n" + release)
+		p := "dists/testing/" + name
+		if err := storage.Store(apt.MakeFileInfo(p, data), data); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	f, err := newPackageFilter(&FilterConfig{Include: []string{"cybozu-abc"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := &Mirror{
+		id: "test",
+		mc: &MirrConfig{
+			Suites:        []string{"testing"},
+			Sections:      []string{"main"},
+			Architectures: []string{"amd64"},
+		},
+		storage: storage,
+		filter:  f,
+	}
+
+	op, err := apt.NewParser(strings.NewReader(release)).ReadOrdered()
+	if err != nil {
+		t.Fatal(err)
+	}
+	si := &suiteIndices{suite: "testing", indices: indices, release: op}
+	err = m.rewriteIndices([]*suiteIndices{si})
+	if err != nil {
+		t.Fatal(err)
+	}
+	newIndices := si.indices
+	if len(newIndices) != 3 {
+		t.Fatal(`len(newIndices) != 3`, len(newIndices))
+	}
+
+	for _, fi := range newIndices {
+		r, err := storage.Open(fi.Path())
+		if err != nil {
+			t.Fatal(err)
+		}
+		fil, _, err := apt.ExtractFileInfo(fi.Path(), r)
+		r.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(fil) != 1 || fil[0].Path() != "pool/c/cybozu-abc_0.2.2-1_amd64.deb" {
+			t.Error(`unexpected items in`, fi.Path())
+		}
+	}
+
+	if _, err := storage.Open("dists/testing/InRelease"); !os.IsNotExist(err) {
+		t.Error(`InRelease must be removed`)
+	}
+	r, err := storage.Open("dists/testing/Release")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fil, d2, err := apt.ExtractFileInfo("dists/testing/Release", r)
+	r.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d2["Origin"][0] != "test" {
+		t.Error(`d2["Origin"][0] != "test"`)
+	}
+	if len(fil) != 4 {
+		t.Fatal(`len(fil) != 4`, len(fil))
+	}
+	for _, fi := range newIndices {
+		found := false
+		for _, fi2 := range fil {
+			if fi2.Same(fi) {
+				found = true
+			}
+		}
+		if !found {
+			t.Error(`Release does not list`, fi.Path())
+		}
+	}
+}
+
+func TestRewriteReleaseSigned(t *testing.T) {
+	t.Parallel()
+
+	d, err := ioutil.TempDir("", "gotest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(d)
+
+	storage, err := NewStorage(d, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := apt.LoadSigner("../apt/testdata/gpg/signing.asc")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	release := "Origin: test\nSuite: testing\nSHA256:\n" +
+		" 0000000000000000000000000000000000000000000000000000000000000000 0 main/binary-amd64/Packages\n"
+	op, err := apt.NewParser(strings.NewReader(release)).ReadOrdered()
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := &Mirror{
+		id: "test",
+		mc: &MirrConfig{
+			Suites:        []string{"testing"},
+			Sections:      []string{"main"},
+			Architectures: []string{"amd64"},
+		},
+		storage: storage,
+		signer:  signer,
+	}
+
+	si := &suiteIndices{suite: "testing", release: op}
+	data := []byte("Packages: none\n")
+	fi := apt.MakeFileInfo("dists/testing/main/binary-amd64/Packages", data)
+	err = m.rewriteRelease(si, map[string]bool{fi.Path(): true}, []*apt.FileInfo{fi})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	read := func(p string) []byte {
+		data, err := readStoredFile(storage, p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return data
+	}
+	rel := read("dists/testing/Release")
+	kr := signer.Keyring()
+
+	_, d1, err := kr.VerifyInRelease(read("dists/testing/InRelease"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, d2, err := kr.VerifyRelease(rel, read("dists/testing/Release.gpg"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(d1, d2) {
+		t.Error(`InRelease and Release must be the same`)
+	}
+	if !strings.Contains(d2["SHA256"][0], hex.EncodeToString(fi.Checksum(apt.ChecksumSHA256))) {
+		t.Error(`Release must list the rewritten index`, d2["SHA256"])
+	}
+}
@@ -8,6 +8,7 @@ import (
 	"sync"
 
 	"github.com/cybozu-go/aptutil/apt"
+	"github.com/cybozu-go/log"
 	"github.com/pkg/errors"
 )
 
@@ -77,6 +78,61 @@ func (s *Storage) Load() error {
 	return nil
 }
 
+// Scan registers files found in the storage directory.
+//
+// This is used to reuse files in a mirror directory left by an
+// interrupted update, which does not have info.json.  Checksums
+// of the files are not calculated until they are looked up.
+func (s *Storage) Scan() error {
+	root := filepath.Join(s.dir, s.prefix)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	wf := func(fp string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) && fp == root {
+				return nil
+			}
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		p, err := filepath.Rel(root, fp)
+		if err != nil {
+			return err
+		}
+		p = filepath.ToSlash(p)
+		s.info[p] = apt.MakeFileInfoNoChecksum(p, uint64(info.Size()))
+		return nil
+	}
+	return filepath.Walk(root, wf)
+}
+
+// verify calculates checksums of a file registered by Scan, or
+// recorded by older versions without SHA512, and updates its info.
+//
+// As the file may be a by-hash link, its FileInfo is constructed
+// with the path of fi.
+func (s *Storage) verify(p string, fi *apt.FileInfo) (*apt.FileInfo, error) {
+	f, err := os.Open(filepath.Join(s.dir, s.prefix, filepath.Clean(p)))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	fi2, err := apt.MakeFileInfoFromReader(fi.Path(), f)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	s.info[p] = fi2
+	s.mu.Unlock()
+	return fi2, nil
+}
+
 // Save saves storage contents persistently.
 func (s *Storage) Save() error {
 	s.mu.Lock()
@@ -171,6 +227,27 @@ func (s *Storage) StoreWithHash(fi *apt.FileInfo, data []byte) error {
 	return s.StoreLinkWithHash(fi, tmpf.Name())
 }
 
+// TempFile creates a new temporary file in the storage directory.
+//
+// The file is created outside of the published tree, and can be
+// stored by StoreLink or StoreLinkWithHash.  The caller is responsible
+// for removing the file.
+func (s *Storage) TempFile() (*os.File, error) {
+	f, err := ioutil.TempFile(s.dir, ".tmp")
+	if err != nil {
+		return nil, errors.Wrap(err, "TempFile")
+	}
+
+	// stored files need to be readable by web servers.
+	err = f.Chmod(0644)
+	if err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return nil, errors.Wrap(err, "TempFile")
+	}
+	return f, nil
+}
+
 // StoreLink stores a hard link to a file into this storage.
 func (s *Storage) StoreLink(fi *apt.FileInfo, fullpath string) error {
 	p := fi.Path()
@@ -202,12 +279,17 @@ func (s *Storage) StoreLinkWithHash(fi *apt.FileInfo, fullpath string) error {
 	md5p := fi.MD5SumPath()
 	sha1p := fi.SHA1Path()
 	sha256p := fi.SHA256Path()
+	sha512p := fi.SHA512Path()
 	fpl := []string{
 		filepath.Join(s.dir, s.prefix, filepath.Clean(p)),
 		filepath.Join(s.dir, s.prefix, filepath.Clean(md5p)),
 		filepath.Join(s.dir, s.prefix, filepath.Clean(sha1p)),
 		filepath.Join(s.dir, s.prefix, filepath.Clean(sha256p)),
 	}
+	// files reused from older mirrors may not have SHA512 checksum.
+	if len(sha512p) > 0 {
+		fpl = append(fpl, filepath.Join(s.dir, s.prefix, filepath.Clean(sha512p)))
+	}
 
 	s.mu.Lock()
 	_, ok := s.info[p]
@@ -228,6 +310,9 @@ func (s *Storage) StoreLinkWithHash(fi *apt.FileInfo, fullpath string) error {
 	s.info[md5p] = fi
 	s.info[sha1p] = fi
 	s.info[sha256p] = fi
+	if len(sha512p) > 0 {
+		s.info[sha512p] = fi
+	}
 	s.mu.Unlock()
 
 	for _, fp := range fpl {
@@ -244,6 +329,51 @@ func (s *Storage) StoreLinkWithHash(fi *apt.FileInfo, fullpath string) error {
 	return nil
 }
 
+// Remove removes a stored file at p, and its by-hash links if any.
+//
+// If p is not stored, this does nothing.
+func (s *Storage) Remove(p string) error {
+	s.mu.Lock()
+	fi, ok := s.info[p]
+	if !ok {
+		s.mu.Unlock()
+		return nil
+	}
+	delete(s.info, p)
+
+	rl := []string{p}
+	for _, hp := range []string{fi.MD5SumPath(), fi.SHA1Path(), fi.SHA256Path(), fi.SHA512Path()} {
+		if len(hp) == 0 {
+			continue
+		}
+		if fi2, ok := s.info[hp]; ok && fi2.Same(fi) {
+			delete(s.info, hp)
+			rl = append(rl, hp)
+		}
+	}
+	s.mu.Unlock()
+
+	for _, rp := range rl {
+		err := os.Remove(filepath.Join(s.dir, s.prefix, filepath.Clean(rp)))
+		if err != nil && !os.IsNotExist(err) {
+			return errors.Wrap(err, "Storage.Remove")
+		}
+	}
+	return nil
+}
+
+// needsVerify returns true if checksums of a stored file fi2 need
+// to be calculated to be compared with fi.
+func needsVerify(fi, fi2 *apt.FileInfo) bool {
+	if fi.HasSize() && fi2.Size() != fi.Size() {
+		return false
+	}
+	if !fi2.HasChecksum() {
+		return true
+	}
+	return fi.Checksum(apt.ChecksumSHA512) != nil && fi2.Checksum(apt.ChecksumSHA512) == nil
+}
+
 // Lookup looks up a file in this storage.
 //
 // If a file matching fi exists, its info and full path is returned.
@@ -251,16 +381,40 @@ func (s *Storage) StoreLinkWithHash(fi *apt.FileInfo, fullpath string) error {
 func (s *Storage) Lookup(fi *apt.FileInfo, byhash bool) (*apt.FileInfo, string) {
 	f := func(p string) (*apt.FileInfo, string) {
 		s.mu.RLock()
-		defer s.mu.RUnlock()
-
 		fi2, ok := s.info[p]
-		if !ok || !fi.Same(fi2) {
+		s.mu.RUnlock()
+
+		if !ok {
+			return nil, ""
+		}
+
+		// delayed checksum calculation for files registered by Scan,
+		// and for files recorded without SHA512 by older versions.
+		if needsVerify(fi, fi2) {
+			var err error
+			fi2, err = s.verify(p, fi)
+			if err != nil {
+				log.Warn("Storage.Lookup", map[string]interface{}{
+					"path":  p,
+					"error": err.Error(),
+				})
+				return nil, ""
+			}
+		}
+
+		if !fi.Same(fi2) {
 			return nil, ""
 		}
 		return fi2, filepath.Join(s.dir, s.prefix, filepath.Clean(p))
 	}
 
 	if byhash {
+		if p := fi.SHA512Path(); len(p) > 0 {
+			fi2, fullpath := f(p)
+			if fi2 != nil {
+				return fi2, fullpath
+			}
+		}
 		fi2, fullpath := f(fi.SHA256Path())
 		if fi2 != nil {
 			return fi2, fullpath
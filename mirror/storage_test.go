@@ -3,6 +3,8 @@ package mirror
 import (
 	"io/ioutil"
 	"os"
+	"path/filepath"
+	"regexp"
 	"testing"
 
 	"github.com/cybozu-go/aptutil/apt"
@@ -108,6 +110,35 @@ func testStorageLookup(t *testing.T) {
 	if fi7 != nil {
 		t.Error(`fi7 != nil`)
 	}
+
+	// info.json written by older versions lacks SHA512.
+	infoPath := filepath.Join(d, infoJSON)
+	data, err := ioutil.ReadFile(infoPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data = regexp.MustCompile(`,"SHA512Sum":"[0-9a-f]*"`).ReplaceAll(data, nil)
+	if err := ioutil.WriteFile(infoPath, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	s3, err := NewStorage(d, "pre")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s3.Load(); err != nil {
+		t.Fatal(err)
+	}
+	fi8, _ := s3.Lookup(apt.MakeFileInfo("def", files["def"]), false)
+	if fi8 == nil {
+		t.Fatal(`files without SHA512 must be reused`)
+	}
+	if fi8.Checksum(apt.ChecksumSHA512) == nil {
+		t.Error(`SHA512 must be calculated`)
+	}
+	fi9, _ := s3.Lookup(apt.MakeFileInfo("a/b/c", []byte{'a', 'b', 'd'}), false)
+	if fi9 != nil {
+		t.Error(`fi9 != nil`)
+	}
 }
 
 func testStorageStore(t *testing.T) {
@@ -163,10 +194,136 @@ func testStorageStore(t *testing.T) {
 	if found == nil {
 		t.Error(`found == nil`, d, fi2.SHA256Path())
 	}
+	f, err := s.Open(fi2.SHA512Path())
+	if err != nil {
+		t.Error(err)
+	} else {
+		f.Close()
+	}
+}
+
+func testStorageScan(t *testing.T) {
+	t.Parallel()
+
+	d, err := ioutil.TempDir("", "gotest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(d)
+
+	s, err := NewStorage(d, "pre")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	abc := []byte{'a', 'b', 'c'}
+	def := []byte{'d', 'e', 'f'}
+	fi := apt.MakeFileInfo("a/b/c", abc)
+	if err := s.Store(fi, abc); err != nil {
+		t.Fatal(err)
+	}
+	fi2 := apt.MakeFileInfo("d/e/f", def)
+	if err := s.StoreWithHash(fi2, def); err != nil {
+		t.Fatal(err)
+	}
+
+	// info.json is not saved.
+	s2, err := NewStorage(d, "pre")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s2.Scan(); err != nil {
+		t.Fatal(err)
+	}
+
+	found, fullpath := s2.Lookup(fi, false)
+	if found == nil {
+		t.Fatal(`found == nil`)
+	}
+	if !found.HasChecksum() || !fi.Same(found) {
+		t.Error(`!found.HasChecksum() || !fi.Same(found)`)
+	}
+	if fullpath != filepath.Join(d, "pre", "a/b/c") {
+		t.Error(`wrong fullpath`, fullpath)
+	}
+
+	// same size, different contents.
+	notfound, _ := s2.Lookup(apt.MakeFileInfo("d/e/f", abc), false)
+	if notfound != nil {
+		t.Error(`notfound != nil`)
+	}
+
+	found, fullpath = s2.Lookup(fi2, true)
+	if found == nil {
+		t.Fatal(`found == nil`)
+	}
+	if !fi2.Same(found) {
+		t.Error(`!fi2.Same(found)`)
+	}
+	if fullpath != filepath.Join(d, "pre", fi2.SHA512Path()) {
+		t.Error(`wrong fullpath`, fullpath)
+	}
+
+	// Scan should succeed for storages without files.
+	d2, err := ioutil.TempDir("", "gotest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(d2)
+	s3, err := NewStorage(d2, "pre")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s3.Scan(); err != nil {
+		t.Error(err)
+	}
+}
+
+func testStorageRemove(t *testing.T) {
+	t.Parallel()
+
+	d, err := ioutil.TempDir("", "gotest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(d)
+
+	s, err := NewStorage(d, "pre")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data := []byte{'a', 'b', 'c'}
+	fi := apt.MakeFileInfo("a/b/c", data)
+	err = s.StoreWithHash(fi, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = s.Remove("a/b/c")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range []string{"a/b/c", fi.SHA256Path(), fi.MD5SumPath()} {
+		if _, err := os.Stat(filepath.Join(d, "pre", p)); !os.IsNotExist(err) {
+			t.Error(`file remains`, p)
+		}
+	}
+	if found, _ := s.Lookup(fi, true); found != nil {
+		t.Error(`found != nil`)
+	}
+
+	// removing non-existent files is not an error.
+	err = s.Remove("a/b/c")
+	if err != nil {
+		t.Error(err)
+	}
 }
 
 func TestStorage(t *testing.T) {
 	t.Run("BadConstruction", testStorageBadConstruction)
 	t.Run("Lookup", testStorageLookup)
 	t.Run("Store", testStorageStore)
+	t.Run("Scan", testStorageScan)
+	t.Run("Remove", testStorageRemove)
 }
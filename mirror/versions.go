@@ -0,0 +1,68 @@
+package mirror
+
+// This file implements pruning of old package versions.
+
+import (
+	"sort"
+
+	"github.com/cybozu-go/aptutil/apt"
+)
+
+// versionsDesc sorts versions from the newest to the oldest.
+type versionsDesc []string
+
+func (v versionsDesc) Len() int {
+	return len(v)
+}
+
+func (v versionsDesc) Less(i, j int) bool {
+	return apt.CompareVersions(v[i], v[j]) > 0
+}
+
+func (v versionsDesc) Swap(i, j int) {
+	v[i], v[j] = v[j], v[i]
+}
+
+// keepNewest keeps only the newest n versions of each package in
+// each index.  Binary packages are distinguished by architecture too.
+func keepNewest(groups []*indexGroup, n int) {
+	for _, g := range groups {
+		pkgs := make(map[string][]*stanza)
+		for _, s := range g.stanzas {
+			if !s.keep {
+				continue
+			}
+			key := fieldValue(s.d, "Package")
+			if !g.source {
+				key += " " + fieldValue(s.d, "Architecture")
+			}
+			pkgs[key] = append(pkgs[key], s)
+		}
+
+		for _, sl := range pkgs {
+			seen := make(map[string]bool)
+			var versions []string
+			for _, s := range sl {
+				v := fieldValue(s.d, "Version")
+				if !seen[v] {
+					seen[v] = true
+					versions = append(versions, v)
+				}
+			}
+			if len(versions) <= n {
+				continue
+			}
+
+			sort.Sort(versionsDesc(versions))
+			old := make(map[string]bool)
+			for _, v := range versions[n:] {
+				old[v] = true
+			}
+			for _, s := range sl {
+				if old[fieldValue(s.d, "Version")] {
+					s.keep = false
+				}
+			}
+		}
+	}
+}
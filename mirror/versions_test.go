@@ -0,0 +1,39 @@
+package mirror
+
+import "testing"
+
+func TestKeepNewest(t *testing.T) {
+	t.Parallel()
+
+	v1 := testStanza("Package", "app", "Architecture", "amd64", "Version", "1.0-1")
+	v2 := testStanza("Package", "app", "Architecture", "amd64", "Version", "1.0-2")
+	v2dup := testStanza("Package", "app", "Architecture", "amd64", "Version", "1.0-2")
+	v3 := testStanza("Package", "app", "Architecture", "amd64", "Version", "1:0.9")
+	rc := testStanza("Package", "app", "Architecture", "amd64", "Version", "2.0~rc1")
+	i386 := testStanza("Package", "app", "Architecture", "i386", "Version", "0.1")
+	lib := testStanza("Package", "lib", "Architecture", "amd64", "Version", "0.1")
+	src1 := testStanza("Package", "app", "Version", "1.0-1")
+	src2 := testStanza("Package", "app", "Version", "1.0-2")
+
+	groups := []*indexGroup{
+		{stanzas: []*stanza{v1, v2, v2dup, v3, rc, i386, lib}},
+		{source: true, stanzas: []*stanza{src1, src2}},
+	}
+	keepNewest(groups, 2)
+
+	for _, s := range []*stanza{v3, rc, i386, lib, src1, src2} {
+		if !s.keep {
+			t.Error(`must be kept:`, s.d["Package"][0], s.d["Version"][0])
+		}
+	}
+	for _, s := range []*stanza{v1, v2, v2dup} {
+		if s.keep {
+			t.Error(`must not be kept:`, s.d["Package"][0], s.d["Version"][0])
+		}
+	}
+
+	keepNewest(groups, 1)
+	if rc.keep || src1.keep || !v3.keep || !src2.keep {
+		t.Error(`only the newest versions must be kept`)
+	}
+}